@@ -0,0 +1,68 @@
+package watcher
+
+import "time"
+
+// adaptiveWeight is the weight of a new interval in the moving average of the intervals between changes
+const adaptiveWeight = 0.3
+
+// WithAdaptiveDebounce replaces the fixed debounce time of a watch with a window between min and max that
+// follows the change frequency. The window is computed from a moving average of the intervals between recent
+// changes: it widens toward max while changes arrive faster than min apart, so bursts are coalesced, and narrows
+// toward min while they arrive more than max apart, so isolated changes are emitted quickly.
+func WithAdaptiveDebounce(min, max time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.adaptiveMin = min
+		o.adaptiveMax = max
+	}
+}
+
+// debounceWindow computes the debounce time for every change, it is only used on the emitter goroutine
+type debounceWindow struct {
+	fixed    time.Duration
+	min, max time.Duration
+	// avg is the moving average of the intervals between changes, zero before the second change
+	avg  time.Duration
+	last time.Time
+}
+
+// newDebounceWindow returns the debounce window of a watch with options o
+func newDebounceWindow(o *watchOptions) *debounceWindow {
+	return &debounceWindow{
+		fixed: o.debounceTime,
+		min:   o.adaptiveMin,
+		max:   o.adaptiveMax,
+	}
+}
+
+// adaptive reports whether the window follows the change frequency
+func (d *debounceWindow) adaptive() bool {
+	return d.max > 0 && d.max >= d.min
+}
+
+// observe records a change at now and returns the debounce time to apply to it
+func (d *debounceWindow) observe(now time.Time) time.Duration {
+	if !d.adaptive() {
+		return d.fixed
+	}
+
+	if !d.last.IsZero() {
+		interval := now.Sub(d.last)
+		if d.avg == 0 {
+			d.avg = interval
+		} else {
+			d.avg = time.Duration(adaptiveWeight*float64(interval) + (1-adaptiveWeight)*float64(d.avg))
+		}
+	}
+	d.last = now
+
+	switch {
+	case d.avg == 0 || d.avg >= d.max:
+		return d.min
+	case d.avg <= d.min:
+		return d.max
+	default:
+		// interpolate linearly, shorter intervals give a longer window
+		slowness := float64(d.avg-d.min) / float64(d.max-d.min)
+		return d.min + time.Duration((1-slowness)*float64(d.max-d.min))
+	}
+}
@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceWindowAdaptive(t *testing.T) {
+	d := newDebounceWindow(&watchOptions{adaptiveMin: 10 * time.Millisecond, adaptiveMax: 100 * time.Millisecond})
+	now := time.Now()
+
+	if window := d.observe(now); window != d.min {
+		t.Fatalf("got %s for the first change, want the min %s", window, d.min)
+	}
+
+	// a burst of changes 1ms apart widens the window to max
+	var window time.Duration
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Millisecond)
+		window = d.observe(now)
+	}
+	if window != d.max {
+		t.Fatalf("got %s during a burst, want the max %s", window, d.max)
+	}
+
+	// the window narrows step by step during a lull of changes a second apart
+	prev := d.max
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		window := d.observe(now)
+		if window > prev {
+			t.Fatalf("got window %s after %s during a lull, want it narrowing", window, prev)
+		}
+		prev = window
+	}
+	if prev != d.min {
+		t.Fatalf("got %s after a lull, want the min %s", prev, d.min)
+	}
+}
+
+func TestDebounceWindowFixed(t *testing.T) {
+	d := newDebounceWindow(&watchOptions{debounceTime: 50 * time.Millisecond})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Millisecond)
+		if window := d.observe(now); window != 50*time.Millisecond {
+			t.Fatalf("got %s, want the fixed 50ms", window)
+		}
+	}
+}
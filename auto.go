@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchAuto watches path without knowing the KV layout in advance. A leaf key emits a single element slice
+// with its key value pair, a directory emits all pairs below it, including a pair of path itself if it exists.
+// The shape is detected on every change with a prefix list, so a leaf that gets children switches to the
+// directory view and back once they are deleted. Keys that only share the prefix of path, e.g. "app-old" for
+// "app", are not part of the view. An empty slice is emitted while nothing exists at path. The channel is closed
+// when the watch ends.
+func (w *Watcher) WatchAuto(ctx context.Context, path string, opts ...WatchOption) (<-chan consul.KVPairs, error) {
+	path = strings.TrimSuffix(path, "/")
+	pairs, err := w.WatchTree(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := w.dirKey(path)
+	leaf := strings.TrimSuffix(dir, "/")
+	out := make(chan consul.KVPairs)
+	go func() {
+		defer close(out)
+
+		var last string
+		first := true
+		for p := range pairs {
+			view := make(consul.KVPairs, 0, len(p))
+			for _, pair := range p {
+				if leaf == "" || pair.Key == leaf || strings.HasPrefix(pair.Key, dir) {
+					view = append(view, pair)
+				}
+			}
+
+			// changes of keys only sharing the prefix don't change the view
+			hash := treeHash(view)
+			if !first && hash == last {
+				continue
+			}
+			first = false
+			last = hash
+
+			select {
+			case out <- view:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, nil
+}
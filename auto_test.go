@@ -0,0 +1,49 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchAuto(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app", []byte("leaf"))
+	kv.Put("app-old", []byte("other"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	views, err := w.WatchAuto(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{name: "leaf", want: []string{"app"}},
+		{name: "leaf becomes directory", change: func() { kv.Put("app/a", []byte("1")) }, want: []string{"app", "app/a"}},
+		{name: "directory", change: func() { kv.Delete("app") }, want: []string{"app/a"}},
+		{name: "removed", change: func() { kv.Delete("app/a") }, want: []string{}},
+		{name: "leaf again", change: func() { kv.Put("app", []byte("leaf")) }, want: []string{"app"}},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		view := <-views
+		if view == nil {
+			t.Fatalf("%s: got a nil view", step.name)
+		}
+		if keys := treeKeys(view); !equalStrings(keys, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, keys, step.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package watcher
+
+// BackpressurePolicy decides what a watch does with new values while the consumer doesn't receive
+type BackpressurePolicy int
+
+const (
+	// Block waits until the consumer received the buffered values, so no value is lost. The
+	// query loop pauses meanwhile. It is the default.
+	Block BackpressurePolicy = iota
+	// DropOldest drops the oldest buffered value to make room for the new one
+	DropOldest
+	// DropNewest drops the new value while the buffer is full
+	DropNewest
+	// Coalesce replaces the newest buffered value with the new one while the buffer is full,
+	// so the consumer always receives the latest value
+	Coalesce
+)
+
+// WithBackpressure sets how a watch handles a consumer that doesn't keep up. Up to size values are buffered,
+// once the buffer is full the policy applies. Without the option or with Block and a size of 0 every value
+// is handed over directly to the consumer. The other policies buffer at least one value. Dropped values don't
+// count as emissions and Meta.Seq only numbers the delivered values. Buffered values are delivered before the
+// channel is closed after an error, on cancellation they are dropped unless WithCloseDrain is set.
+func WithBackpressure(policy BackpressurePolicy, size int) WatchOption {
+	return func(o *watchOptions) {
+		o.backpressure = policy
+		o.bufferSize = size
+	}
+}
+
+// queued is a change waiting in the buffer of a watch
+type queued[T any] struct {
+	c         change[T]
+	debounced bool
+}
+
+// emitBuffer buffers the emissions of a watch according to its backpressure policy,
+// it is only used on the emitter goroutine
+type emitBuffer[T any] struct {
+	policy BackpressurePolicy
+	size   int
+	items  []queued[T]
+}
+
+// newEmitBuffer returns the buffer for a watch with options o
+func newEmitBuffer[T any](o *watchOptions) *emitBuffer[T] {
+	size := o.bufferSize
+	if o.backpressure != Block && size < 1 {
+		size = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+
+	return &emitBuffer[T]{policy: o.backpressure, size: size}
+}
+
+// enabled reports whether values are buffered at all
+func (b *emitBuffer[T]) enabled() bool {
+	return b.size > 0
+}
+
+// push adds item according to the policy. It returns false if the buffer is full and the policy is Block,
+// then the head has to be delivered before.
+func (b *emitBuffer[T]) push(item queued[T]) bool {
+	if len(b.items) < b.size {
+		b.items = append(b.items, item)
+		return true
+	}
+
+	switch b.policy {
+	case DropOldest:
+		b.items = append(b.items[1:], item)
+		return true
+	case DropNewest:
+		return true
+	case Coalesce:
+		b.items[len(b.items)-1] = item
+		return true
+	default:
+		return false
+	}
+}
+
+// head returns the oldest buffered item
+func (b *emitBuffer[T]) head() (queued[T], bool) {
+	if len(b.items) == 0 {
+		return queued[T]{}, false
+	}
+
+	return b.items[0], true
+}
+
+// pop removes the oldest buffered item
+func (b *emitBuffer[T]) pop() {
+	var zero queued[T]
+	b.items[0] = zero
+	b.items = b.items[1:]
+}
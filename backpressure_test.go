@@ -0,0 +1,162 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy watcher.BackpressurePolicy
+		size   int
+		want   []string
+	}{
+		{name: "drop oldest", policy: watcher.DropOldest, size: 2, want: []string{"3", "4"}},
+		{name: "drop newest", policy: watcher.DropNewest, size: 2, want: []string{"1", "2"}},
+		{name: "coalesce", policy: watcher.Coalesce, size: 2, want: []string{"1", "4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("key", []byte("0"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			var changed int32
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", watcher.WithBackpressure(tt.policy, tt.size),
+				watcher.WithOnPollComplete(func(c bool) {
+					if c {
+						atomic.AddInt32(&changed, 1)
+					}
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+
+			// the consumer doesn't receive while the values 1 to 4 are read
+			for i := 1; i <= 4; i++ {
+				kv.Put("key", []byte(fmt.Sprint(i)))
+				waitFor(t, func() bool {
+					return atomic.LoadInt32(&changed) == int32(i+1)
+				})
+			}
+			// the last value is buffered by the emitter after the query returned
+			time.Sleep(20 * time.Millisecond)
+
+			for _, want := range tt.want {
+				pair := <-pairs
+				if string(pair.Value) != want {
+					t.Fatalf("got %s, want %s", pair.Value, want)
+				}
+			}
+			select {
+			case pair := <-pairs:
+				t.Fatalf("got unexpected value %s", pair.Value)
+			case <-time.After(30 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// withheld puts every value at key while the consumer doesn't receive and waits until the last one was read
+func withheld(t *testing.T, kv *watchertest.KV, changed *int32, key string, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		want := atomic.LoadInt32(changed) + 1
+		kv.Put(key, []byte(value))
+		waitFor(t, func() bool {
+			return atomic.LoadInt32(changed) == want
+		})
+	}
+	// the last value is buffered by the emitter after the query returned
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestBackpressureKeyWithMeta(t *testing.T) {
+	policies := map[string]watcher.BackpressurePolicy{"coalesce": watcher.Coalesce, "drop oldest": watcher.DropOldest}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("key", []byte("1"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			var changed int32
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKeyWithMeta(ctx, "key", watcher.WithBackpressure(policy, 1),
+				watcher.WithOnPollComplete(func(c bool) {
+					if c {
+						atomic.AddInt32(&changed, 1)
+					}
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := <-pairs
+			if first.ModifyDelta != 0 {
+				t.Fatalf("got delta %d, want 0 on the first emission", first.ModifyDelta)
+			}
+
+			// the values replaced in the buffer never reach the consumer, so the delta spans all of them
+			withheld(t, kv, &changed, "key", "2", "3", "4")
+			pair := <-pairs
+			if pair.ModifyIndex != first.ModifyIndex+3 || pair.ModifyDelta != 3 || pair.Seq != 2 {
+				t.Fatalf("got index %d delta %d seq %d, want %d, 3 and 2",
+					pair.ModifyIndex, pair.ModifyDelta, pair.Seq, first.ModifyIndex+3)
+			}
+		})
+	}
+}
+
+func TestBackpressureTreeWithMeta(t *testing.T) {
+	policies := map[string]watcher.BackpressurePolicy{"coalesce": watcher.Coalesce, "drop oldest": watcher.DropOldest}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("app/a", []byte("1"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			var changed int32
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			trees, err := w.WatchTreeWithMeta(ctx, "app/", watcher.WithBackpressure(policy, 1),
+				watcher.WithOnPollComplete(func(c bool) {
+					if c {
+						atomic.AddInt32(&changed, 1)
+					}
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := <-trees
+			if first.Change != watcher.ChangeOnlyAdds {
+				t.Fatalf("got %s, want only-adds", first.Change)
+			}
+
+			// app/b is added and app/a modified while the consumer doesn't receive, the snapshot with
+			// only app/b added is replaced in the buffer
+			withheld(t, kv, &changed, "app/b", "1")
+			withheld(t, kv, &changed, "app/a", "2")
+			tree := <-trees
+			if tree.Change != watcher.ChangeMixed {
+				t.Fatalf("got %s, want mixed against the delivered snapshot", tree.Change)
+			}
+			if tree.ModifyIndex != first.ModifyIndex+2 || tree.ModifyDelta != 2 {
+				t.Fatalf("got index %d delta %d, want %d and 2", tree.ModifyIndex, tree.ModifyDelta, first.ModifyIndex+2)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures that opens the circuit breaker
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is the time an open circuit breaker pauses all retries
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerState is the state of the circuit breaker shared by all watches of a Watcher
+type BreakerState int
+
+const (
+	// BreakerDisabled means no circuit breaker is configured
+	BreakerDisabled BreakerState = iota
+	// BreakerClosed lets all requests through
+	BreakerClosed
+	// BreakerOpen pauses all requests until the cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single request through to test if Consul has recovered
+	BreakerHalfOpen
+)
+
+// String returns the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "disabled"
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker shared by all watches of the Watcher. After threshold
+// consecutive retryable failures across all watches the breaker opens and pauses all requests for cooldown.
+// Afterwards a single request is let through, if it succeeds the breaker closes again, otherwise it re-opens.
+// Values <= 0 use DefaultBreakerThreshold and DefaultBreakerCooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(w *Watcher) {
+		if threshold <= 0 {
+			threshold = DefaultBreakerThreshold
+		}
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+
+		w.breaker = &circuitBreaker{
+			threshold: threshold,
+			cooldown:  cooldown,
+			state:     BreakerClosed,
+			changed:   make(chan struct{}),
+		}
+	}
+}
+
+// circuitBreaker coordinates retries across watches, a nil breaker lets everything through
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     BreakerState
+	openedAt  time.Time
+	probing   bool
+	// probe numbers the half-open probes, so a watch can only release its own probe
+	probe uint64
+	// changed is closed and replaced on every state change to wake up waiting watches
+	changed chan struct{}
+}
+
+// State returns the current state of the breaker
+func (b *circuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerDisabled
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// wait blocks until the breaker lets a request through or ctx is done. If the request is the half-open probe,
+// the returned release func must be called once it ended without success or failure being recorded, e.g. because
+// it was cancelled, so other watches don't wait for its result forever. release is a no-op otherwise.
+func (b *circuitBreaker) wait(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	for {
+		b.mu.Lock()
+		var delay <-chan time.Time
+		switch b.state {
+		case BreakerOpen:
+			remaining := b.cooldown - time.Since(b.openedAt)
+			if remaining <= 0 {
+				b.setState(BreakerHalfOpen)
+				release := b.startProbe()
+				b.mu.Unlock()
+				return release, nil
+			}
+			delay = time.After(remaining)
+		case BreakerHalfOpen:
+			if !b.probing {
+				release := b.startProbe()
+				b.mu.Unlock()
+				return release, nil
+			}
+		default:
+			b.mu.Unlock()
+			return func() {}, nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return func() {}, ctx.Err()
+		case <-changed:
+		case <-delay:
+		}
+	}
+}
+
+// startProbe marks a probe as running and returns the func releasing it, b.mu must be held
+func (b *circuitBreaker) startProbe() func() {
+	b.probing = true
+	b.probe++
+	probe := b.probe
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.probing && b.probe == probe {
+			// the probe ended without result, let the next waiting watch probe
+			b.probing = false
+			b.wake()
+		}
+	}
+}
+
+// success records a successful request and closes the breaker
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+// failure records a failed request and opens the breaker if the threshold is reached
+// or the half-open probe failed
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState changes the state and wakes up all waiting watches, b.mu must be held
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.wake()
+}
+
+// wake wakes up all waiting watches, b.mu must be held
+func (b *circuitBreaker) wake() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
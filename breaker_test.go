@@ -0,0 +1,81 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// stuckKV blocks reads of a key until the query is cancelled once stuck is set
+type stuckKV struct {
+	*watchertest.KV
+	key     string
+	entered chan struct{}
+
+	mu    sync.Mutex
+	stuck bool
+}
+
+func (kv *stuckKV) setStuck() {
+	kv.mu.Lock()
+	kv.stuck = true
+	kv.mu.Unlock()
+}
+
+func (kv *stuckKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	kv.mu.Lock()
+	stuck := kv.stuck
+	kv.mu.Unlock()
+	if stuck && key == kv.key {
+		kv.entered <- struct{}{}
+		<-q.Context().Done()
+		return nil, nil, q.Context().Err()
+	}
+
+	return kv.KV.Get(key, q)
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	kv := &stuckKV{KV: watchertest.NewKV(), key: "probe", entered: make(chan struct{}, 1)}
+	kv.SetError(errors.New("Unexpected response code: 500"))
+	w := watcher.New(nil, 10*time.Millisecond, 10*time.Millisecond,
+		watcher.WithKVClient(kv), watcher.WithCircuitBreaker(1, 50*time.Millisecond))
+
+	probeCtx, cancelProbe := context.WithCancel(context.Background())
+	defer cancelProbe()
+	if _, err := w.WatchKey(probeCtx, "probe"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return w.Stats().Breaker == watcher.BreakerOpen })
+	kv.setStuck()
+	kv.SetError(nil)
+	select {
+	case <-kv.entered:
+	case <-time.After(time.Second):
+		t.Fatal("half-open probe not started")
+	}
+	if state := w.Stats().Breaker; state != watcher.BreakerHalfOpen {
+		t.Fatalf("breaker is %s during the probe, want half-open", state)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelProbe()
+
+	select {
+	case <-pairs:
+	case <-time.After(time.Second):
+		t.Fatal("second watch made no progress after the probe was cancelled")
+	}
+}
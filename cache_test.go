@@ -0,0 +1,76 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// outageKV is an agent cache holding pair while the servers are unavailable until outage is closed
+type outageKV struct {
+	*watchertest.KV
+	pair   *consul.KVPair
+	outage chan struct{}
+}
+
+func (kv *outageKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	select {
+	case <-kv.outage:
+		return kv.KV.Get(key, q)
+	default:
+	}
+	if q.StaleIfError == 0 || q.MaxAge == 0 {
+		return nil, nil, errors.New("Unexpected response code: 500 (No cluster leader)")
+	}
+	if q.WaitIndex >= kv.pair.ModifyIndex {
+		select {
+		case <-kv.outage:
+			return kv.KV.Get(key, q)
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+	}
+
+	return kv.pair, &consul.QueryMeta{
+		LastIndex:   kv.pair.ModifyIndex,
+		KnownLeader: true,
+		CacheHit:    true,
+		CacheAge:    time.Hour,
+	}, nil
+}
+
+func TestStaleIfError(t *testing.T) {
+	checkGoroutines(t)
+	fake := watchertest.NewKV()
+	fake.Put("key", []byte("cached"))
+	cached, _, err := fake.Get("key", &consul.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Put("key", []byte("fresh"))
+	kv := &outageKV{KV: fake, pair: cached, outage: make(chan struct{})}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "key", watcher.WithMaxAge(time.Minute),
+		watcher.WithStaleIfError(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache serves the last known value during the outage
+	if pair := <-pairs; string(pair.Pair.Value) != "cached" || !pair.Stale {
+		t.Fatalf("got %s stale %v, want the stale cached value", pair.Pair.Value, pair.Stale)
+	}
+
+	close(kv.outage)
+	if pair := <-pairs; string(pair.Pair.Value) != "fresh" || pair.Stale {
+		t.Fatalf("got %s stale %v, want the fresh value", pair.Pair.Value, pair.Stale)
+	}
+}
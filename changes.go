@@ -0,0 +1,174 @@
+package watcher
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ChangeType describes how a key changed between two snapshots of a tree
+type ChangeType int
+
+const (
+	// Created means the key did not exist before or was deleted and re-created
+	Created ChangeType = iota + 1
+	// Updated means the value of an existing key was modified
+	Updated
+	// Deleted means the key no longer exists
+	Deleted
+)
+
+// String returns the name of the change type
+func (t ChangeType) String() string {
+	switch t {
+	case Created:
+		return "created"
+	case Updated:
+		return "updated"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// KVChange is the change of a single key in a watched tree
+type KVChange struct {
+	Type ChangeType
+	// Pair is the new key value pair, for deleted keys it is the last known pair
+	Pair *consul.KVPair
+	// Self is true if the key is the watched path itself or its directory marker with a trailing slash,
+	// false for the keys below it. In Consul a path can be a key with a value and the prefix of children.
+	Self bool
+}
+
+// WatchTreeChanges watches for changes to a directory and emits the keys that were created, updated
+// or deleted compared to the previous snapshot, sorted by key. The first emission contains all
+// existing keys as created. A key is classified as created instead of updated if its CreateIndex
+// differs from the previous snapshot, i.e. it was deleted and re-added in between. Changes of the key
+// at path itself are marked with Self to tell them from changes of its children.
+func (w *Watcher) WatchTreeChanges(ctx context.Context, path string, opts ...WatchOption) (<-chan []KVChange, error) {
+	o := w.newWatchOptions(opts)
+	snapshots, err := startWatch(ctx, w, o, w.treeSource(path, o), valueOnly[consul.KVPairs])
+	if err != nil {
+		return nil, err
+	}
+
+	full := w.emittedKey(path)
+	marker := strings.TrimSuffix(full, "/") + "/"
+	out := make(chan []KVChange)
+	go func() {
+		defer close(out)
+
+		diffSnapshots(snapshots, o.deleteGrace, func(created, updated, deleted consul.KVPairs) {
+			changes := diffChanges(created, updated, deleted)
+			for i := range changes {
+				changes[i].Self = changes[i].Pair.Key == full || changes[i].Pair.Key == marker
+			}
+
+			select {
+			case out <- changes:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return out, nil
+}
+
+// WatchTreeApply watches for changes to a directory and calls apply once per debounced change with all
+// keys that were created, updated and deleted compared to the previous snapshot, so derived state can be
+// updated in one step without exposing intermediate states. The first call contains all existing keys as
+// created. Errors returned by apply are passed to the error handler and the watch continues. Calls of apply
+// never overlap and are in order, with WithDeliveryWorkers they run on the worker pool of the Watcher.
+func (w *Watcher) WatchTreeApply(
+	ctx context.Context, path string, apply func(created, updated, deleted consul.KVPairs) error, opts ...WatchOption,
+) error {
+	o := w.newWatchOptions(opts)
+	snapshots, err := startWatch(ctx, w, o, w.treeSource(path, o), valueOnly[consul.KVPairs])
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		d := w.newDeliveries(o)
+		defer d.close()
+
+		diffSnapshots(snapshots, o.deleteGrace, func(created, updated, deleted consul.KVPairs) {
+			d.deliver(ctx, func() {
+				if err := apply(created, updated, deleted); err != nil {
+					o.handleError(err)
+				}
+			})
+		})
+	}()
+
+	return nil
+}
+
+// DiffKVPairs compares two snapshots of a tree by key. A key is created if it is missing in old or its
+// CreateIndex differs, i.e. it was deleted and re-added in between. It is updated if its ModifyIndex
+// differs and deleted if it is missing in new, deleted pairs are taken from old. All results are sorted
+// by key. Nil snapshots are treated as empty and nil pairs are ignored, the results are nil if empty.
+func DiffKVPairs(old, new consul.KVPairs) (created, updated, deleted consul.KVPairs) {
+	oldByKey := make(map[string]*consul.KVPair, len(old))
+	for _, pair := range old {
+		if pair != nil {
+			oldByKey[pair.Key] = pair
+		}
+	}
+
+	for _, pair := range new {
+		if pair == nil {
+			continue
+		}
+
+		prev, ok := oldByKey[pair.Key]
+		delete(oldByKey, pair.Key)
+		switch {
+		case !ok || prev.CreateIndex != pair.CreateIndex:
+			created = append(created, pair)
+		case prev.ModifyIndex != pair.ModifyIndex:
+			updated = append(updated, pair)
+		}
+	}
+
+	for _, pair := range oldByKey {
+		deleted = append(deleted, pair)
+	}
+
+	sortPairs(created)
+	sortPairs(updated)
+	sortPairs(deleted)
+
+	return created, updated, deleted
+}
+
+// diffChanges returns the changes of the results of DiffKVPairs sorted by key
+func diffChanges(created, updated, deleted consul.KVPairs) []KVChange {
+	changes := make([]KVChange, 0, len(created)+len(updated)+len(deleted))
+	for _, pair := range created {
+		changes = append(changes, KVChange{Type: Created, Pair: pair})
+	}
+	for _, pair := range updated {
+		changes = append(changes, KVChange{Type: Updated, Pair: pair})
+	}
+	for _, pair := range deleted {
+		changes = append(changes, KVChange{Type: Deleted, Pair: pair})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Pair.Key < changes[j].Pair.Key
+	})
+
+	return changes
+}
+
+// sortPairs sorts pairs by key in place
+func sortPairs(pairs consul.KVPairs) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+}
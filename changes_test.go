@@ -0,0 +1,296 @@
+package watcher_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// pair returns a pair of key with the given create and modify index
+func pair(key string, create, modify uint64) *consul.KVPair {
+	return &consul.KVPair{Key: key, CreateIndex: create, ModifyIndex: modify}
+}
+
+func TestDiffKVPairs(t *testing.T) {
+	tests := []struct {
+		name                      string
+		old, new                  consul.KVPairs
+		created, updated, deleted []string
+	}{
+		{
+			name: "both nil",
+		},
+		{
+			name:    "from nil",
+			new:     consul.KVPairs{pair("b", 2, 2), pair("a", 1, 1)},
+			created: []string{"a", "b"},
+		},
+		{
+			name:    "to nil",
+			old:     consul.KVPairs{pair("b", 2, 2), pair("a", 1, 1)},
+			deleted: []string{"a", "b"},
+		},
+		{
+			name: "unchanged",
+			old:  consul.KVPairs{pair("a", 1, 1)},
+			new:  consul.KVPairs{pair("a", 1, 1)},
+		},
+		{
+			name:    "updated",
+			old:     consul.KVPairs{pair("a", 1, 1), pair("b", 2, 2)},
+			new:     consul.KVPairs{pair("a", 1, 3), pair("b", 2, 2)},
+			updated: []string{"a"},
+		},
+		{
+			name:    "re-created",
+			old:     consul.KVPairs{pair("a", 1, 1)},
+			new:     consul.KVPairs{pair("a", 5, 5)},
+			created: []string{"a"},
+		},
+		{
+			name:    "mixed",
+			old:     consul.KVPairs{pair("c", 3, 3), pair("a", 1, 1), pair("b", 2, 2)},
+			new:     consul.KVPairs{pair("d", 4, 4), pair("a", 1, 6), pair("e", 7, 7)},
+			created: []string{"d", "e"},
+			updated: []string{"a"},
+			deleted: []string{"b", "c"},
+		},
+		{
+			name:    "nil pairs",
+			old:     consul.KVPairs{nil, pair("a", 1, 1)},
+			new:     consul.KVPairs{pair("b", 2, 2), nil},
+			created: []string{"b"},
+			deleted: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created, updated, deleted := watcher.DiffKVPairs(tt.old, tt.new)
+			for _, result := range []struct {
+				name string
+				got  consul.KVPairs
+				want []string
+			}{
+				{name: "created", got: created, want: tt.created},
+				{name: "updated", got: updated, want: tt.updated},
+				{name: "deleted", got: deleted, want: tt.deleted},
+			} {
+				if result.want == nil && result.got != nil {
+					t.Errorf("got %s %v, want nil", result.name, treeKeys(result.got))
+				}
+				if got := treeKeys(result.got); !equalStrings(got, result.want) {
+					t.Errorf("got %s %v, want %v", result.name, got, result.want)
+				}
+			}
+		})
+	}
+}
+
+// changeTypes returns the keys of changes with their type, e.g. "created app/a"
+func changeTypes(changes []watcher.KVChange) []string {
+	types := make([]string, 0, len(changes))
+	for _, change := range changes {
+		types = append(types, change.Type.String()+" "+change.Pair.Key)
+	}
+	return types
+}
+
+func TestWatchTreeChanges(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app", []byte("self"))
+	kv.Put("app/a", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := w.WatchTreeChanges(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := <-changes
+	if got := changeTypes(first); !equalStrings(got, []string{"created app", "created app/a"}) {
+		t.Fatalf("got %v, want app and app/a created", got)
+	}
+	if !first[0].Self || first[1].Self {
+		t.Fatal("got wrong Self, want it only for app")
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{name: "create", change: func() { kv.Put("app/b", []byte("2")) }, want: []string{"created app/b"}},
+		{name: "update", change: func() { kv.Put("app/a", []byte("3")) }, want: []string{"updated app/a"}},
+		{name: "delete", change: func() { kv.Delete("app/b") }, want: []string{"deleted app/b"}},
+		{
+			name: "re-create",
+			change: func() {
+				// deleted and re-added between two snapshots, the CreateIndex changed
+				kv.Delete("app/a")
+				kv.Put("app/a", []byte("4"))
+			},
+			want: []string{"created app/a"},
+		},
+	}
+	for _, step := range steps {
+		step.change()
+		var got []string
+		for len(got) < len(step.want) {
+			got = append(got, changeTypes(<-changes)...)
+		}
+		// a re-create may be seen as a delete and a create if a snapshot was taken in between
+		if step.name == "re-create" && len(got) == 1 && got[0] == "deleted app/a" {
+			got = changeTypes(<-changes)
+		}
+		if !equalStrings(got, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestWatchTreeChangesSelf(t *testing.T) {
+	tests := []struct {
+		name, path, self string
+	}{
+		{name: "key", path: "app", self: "app"},
+		{name: "directory marker", path: "app/", self: "app/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put(tt.self, []byte("1"))
+			kv.Put("app/a", []byte("1"))
+			kv.Put("app/b", []byte("1"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes, err := w.WatchTreeChanges(ctx, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-changes
+
+			// only the value of the path itself changes, the children are stable
+			kv.Put(tt.self, []byte("2"))
+			got := <-changes
+			if types := changeTypes(got); !equalStrings(types, []string{"updated " + tt.self}) || !got[0].Self {
+				t.Fatalf("got %v, want only %s updated as Self", types, tt.self)
+			}
+		})
+	}
+}
+
+// gappyKV leaves a key out of the next tree listing like a follower serving an inconsistent snapshot
+type gappyKV struct {
+	*watchertest.KV
+	hide atomic.Value
+}
+
+func (kv *gappyKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	pairs, meta, err := kv.KV.List(prefix, q)
+	hide, _ := kv.hide.Swap("").(string)
+	if err != nil || hide == "" {
+		return pairs, meta, err
+	}
+
+	var visible consul.KVPairs
+	for _, pair := range pairs {
+		if pair.Key != hide {
+			visible = append(visible, pair)
+		}
+	}
+	return visible, meta, nil
+}
+
+func TestDeleteGrace(t *testing.T) {
+	checkGoroutines(t)
+	kv := &gappyKV{KV: watchertest.NewKV()}
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	polls := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := w.WatchTreeChanges(ctx, "app/", watcher.WithDeleteGrace(50*time.Millisecond),
+		watcher.WithOnPollComplete(func(changed bool) {
+			polls <- changed
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-changes
+	<-polls
+
+	// app/b is missing from one snapshot and present again in the next one
+	kv.hide.Store("app/b")
+	kv.Put("other", []byte("1"))
+	<-polls
+	kv.Put("app/c", []byte("1"))
+	if got := changeTypes(<-changes); !equalStrings(got, []string{"created app/c"}) {
+		t.Fatalf("got %v, want only app/c created", got)
+	}
+
+	// a real deletion is reported once the grace elapsed
+	start := time.Now()
+	kv.Delete("app/b")
+	if got := changeTypes(<-changes); !equalStrings(got, []string{"deleted app/b"}) {
+		t.Fatalf("got %v, want app/b deleted", got)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("got the deletion after %s, want it held back for the grace", elapsed)
+	}
+}
+
+func TestWatchTreeApply(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("1"))
+	// the debounce merges the following writes into a single change
+	w := watcher.New(nil, 10*time.Millisecond, 50*time.Millisecond, watcher.WithKVClient(kv))
+
+	type applied struct {
+		created, updated, deleted []string
+	}
+	calls := make(chan applied, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := w.WatchTreeApply(ctx, "app/", func(created, updated, deleted consul.KVPairs) error {
+		calls <- applied{created: treeKeys(created), updated: treeKeys(updated), deleted: treeKeys(deleted)}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call := <-calls; !equalStrings(call.created, []string{"app/a", "app/b"}) {
+		t.Fatalf("got created %v, want all existing keys", call.created)
+	}
+
+	kv.Put("app/a", []byte("2"))
+	kv.Delete("app/b")
+	kv.Put("app/c", []byte("1"))
+	call := <-calls
+	if !equalStrings(call.created, []string{"app/c"}) || !equalStrings(call.updated, []string{"app/a"}) ||
+		!equalStrings(call.deleted, []string{"app/b"}) {
+		t.Fatalf("got created %v updated %v deleted %v, want all changes in one call",
+			call.created, call.updated, call.deleted)
+	}
+	select {
+	case call := <-calls:
+		t.Fatalf("got another call %+v", call)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
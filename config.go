@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ConfigSnapshot is the current state of a watched config directory
+type ConfigSnapshot struct {
+	// Values are all values keyed by their path relative to the watched prefix
+	Values map[string][]byte
+	// Changed are the relative paths created or updated since the previous snapshot, sorted
+	Changed []string
+	// Removed are the relative paths deleted since the previous snapshot, sorted
+	Removed []string
+}
+
+// WatchConfig watches for changes to a config directory and emits its full state together with the
+// paths that changed since the previous snapshot. A prefix is always a directory, "config" only contains
+// the keys below "config/" and paths are relative to it, the key of the directory itself is left out.
+// The first snapshot reports all existing paths as changed.
+func (w *Watcher) WatchConfig(ctx context.Context, prefix string, opts ...WatchOption) (<-chan ConfigSnapshot, error) {
+	snapshots, err := w.WatchTree(ctx, dirPath(prefix), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// emitted keys contain the key prefix of the Watcher
+	dir := w.dirKey(prefix)
+	out := make(chan ConfigSnapshot)
+	go func() {
+		defer close(out)
+
+		var prev consul.KVPairs
+		first := true
+		for pairs := range snapshots {
+			pairs = children(pairs, dir)
+			changes := diffChanges(DiffKVPairs(prev, pairs))
+			prev = pairs
+			if len(changes) == 0 && !first {
+				continue
+			}
+			first = false
+
+			snapshot := ConfigSnapshot{
+				Values: make(map[string][]byte, len(pairs)),
+			}
+			for _, pair := range pairs {
+				snapshot.Values[strings.TrimPrefix(pair.Key, dir)] = pair.Value
+			}
+			for _, c := range changes {
+				name := strings.TrimPrefix(c.Pair.Key, dir)
+				if c.Type == Deleted {
+					snapshot.Removed = append(snapshot.Removed, name)
+				} else {
+					snapshot.Changed = append(snapshot.Changed, name)
+				}
+			}
+
+			select {
+			case out <- snapshot:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// children returns the pairs with keys below dir, without the key of dir itself
+func children(pairs consul.KVPairs, dir string) consul.KVPairs {
+	below := make(consul.KVPairs, 0, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasPrefix(pair.Key, dir) && pair.Key != dir {
+			below = append(below, pair)
+		}
+	}
+
+	return below
+}
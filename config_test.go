@@ -0,0 +1,74 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchConfig(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("config/", nil)
+	kv.Put("config/a", []byte("1"))
+	kv.Put("config/b", []byte("1"))
+	// the debounce merges the following writes into a single snapshot
+	w := watcher.New(nil, 10*time.Millisecond, 50*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots, err := w.WatchConfig(ctx, "config/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := <-snapshots
+	if !equalStrings(snapshot.Changed, []string{"a", "b"}) || len(snapshot.Removed) != 0 {
+		t.Fatalf("got changed %v removed %v, want all paths changed", snapshot.Changed, snapshot.Removed)
+	}
+
+	kv.Put("config/a", []byte("2"))
+	kv.Delete("config/b")
+	kv.Put("config/c", []byte("1"))
+	snapshot = <-snapshots
+	if !equalStrings(snapshot.Changed, []string{"a", "c"}) {
+		t.Fatalf("got changed %v, want [a c]", snapshot.Changed)
+	}
+	if !equalStrings(snapshot.Removed, []string{"b"}) {
+		t.Fatalf("got removed %v, want [b]", snapshot.Removed)
+	}
+	if len(snapshot.Values) != 2 || string(snapshot.Values["a"]) != "2" || string(snapshot.Values["c"]) != "1" {
+		t.Fatalf("got values %v, want a=2 and c=1", snapshot.Values)
+	}
+}
+
+func TestWatchConfigSiblingPrefix(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("config/db", []byte("1"))
+	kv.Put("configuration/legacy", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the prefix has no trailing slash, the sibling key sharing it is not part of the directory
+	snapshots, err := w.WatchConfig(ctx, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := <-snapshots
+	if !equalStrings(snapshot.Changed, []string{"db"}) || len(snapshot.Values) != 1 || snapshot.Values["db"] == nil {
+		t.Fatalf("got changed %v values %v, want only db", snapshot.Changed, snapshot.Values)
+	}
+
+	kv.Put("configuration/legacy", []byte("2"))
+	kv.Put("config/db", []byte("2"))
+	snapshot = <-snapshots
+	if !equalStrings(snapshot.Changed, []string{"db"}) || string(snapshot.Values["db"]) != "2" {
+		t.Fatalf("got changed %v values %v, want db changed to 2", snapshot.Changed, snapshot.Values)
+	}
+}
@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"reflect"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WithCopyValues makes a watch emit deep copies of the key value pairs. With the agent cache the
+// emitted pairs and their values can be shared with other readers of the same cache entry, so a
+// consumer that modifies an emitted Value in place corrupts the cached data. Copying guarantees that
+// emitted pairs are owned by the consumer at the cost of an allocation per emission.
+func WithCopyValues() WatchOption {
+	return func(o *watchOptions) {
+		o.copyValues = true
+	}
+}
+
+// copyPair returns a deep copy of pair, nil stays nil
+func copyPair(pair *consul.KVPair) *consul.KVPair {
+	if pair == nil {
+		return nil
+	}
+
+	c := *pair
+	if pair.Value != nil {
+		c.Value = append([]byte(nil), pair.Value...)
+	}
+	return &c
+}
+
+// copyPairs returns a deep copy of pairs, nil stays nil
+func copyPairs(pairs consul.KVPairs) consul.KVPairs {
+	if pairs == nil {
+		return nil
+	}
+
+	c := make(consul.KVPairs, len(pairs))
+	for i, pair := range pairs {
+		c[i] = copyPair(pair)
+	}
+	return c
+}
+
+// copyValue returns a deep copy of v following pointers, maps, slices, arrays, interfaces and the exported
+// fields of structs, unexported fields are copied shallowly. v must not contain cycles.
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
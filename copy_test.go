@@ -0,0 +1,62 @@
+package watcher_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// sharedKV returns the same pairs to all readers as long as they are unchanged, like the agent cache
+type sharedKV struct {
+	*watchertest.KV
+	mu    sync.Mutex
+	pairs map[string]*consul.KVPair
+}
+
+func (kv *sharedKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	pairs, meta, err := kv.KV.List(prefix, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	shared := make(consul.KVPairs, 0, len(pairs))
+	for _, pair := range pairs {
+		if cached, ok := kv.pairs[pair.Key]; ok && cached.ModifyIndex == pair.ModifyIndex {
+			pair = cached
+		}
+		kv.pairs[pair.Key] = pair
+		shared = append(shared, pair)
+	}
+	return shared, meta, nil
+}
+
+func TestCopyValues(t *testing.T) {
+	checkGoroutines(t)
+	kv := &sharedKV{KV: watchertest.NewKV(), pairs: make(map[string]*consul.KVPair)}
+	kv.Put("app/a", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTree(ctx, "app/", watcher.WithCopyValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer modifies the emitted value in place
+	tree := <-trees
+	tree[0].Value[0] = 'x'
+
+	kv.Put("app/b", []byte("1"))
+	tree = <-trees
+	if len(tree) != 2 || string(tree[0].Value) != "1" {
+		t.Fatalf("got %v with app/a %s, want the unchanged 1", treeKeys(tree), tree[0].Value)
+	}
+}
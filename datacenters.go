@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// DatacenterRefreshInterval is the interval in which WatchKeyAllDatacenters looks for new datacenters
+const DatacenterRefreshInterval = time.Minute
+
+// datacenterRefreshInterval is the refresh interval used by WatchKeyAllDatacenters, it is only changed by tests
+var datacenterRefreshInterval = DatacenterRefreshInterval
+
+// DCKVPair is a key value pair tagged with the datacenter it was read from
+type DCKVPair struct {
+	Datacenter string
+	Pair       *consul.KVPair
+}
+
+// WatchKeyAllDatacenters watches for changes to a key in every known datacenter and emits
+// the key value pairs of all datacenters on one channel. Datacenters are re-enumerated
+// periodically: newly added datacenters are picked up, the watches of removed datacenters are stopped
+// and watches that ended with an error are started again. The channel is closed
+// after all per datacenter watches have exited. Every datacenter is watched by its own watch with separate
+// change detection, an emission in one datacenter never suppresses the same value in another one.
+// The watches are listed with their Datacenter by List.
+func (w *Watcher) WatchKeyAllDatacenters(
+	ctx context.Context, key string, opts ...WatchOption,
+) (<-chan DCKVPair, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	catalog := w.catalog
+	if catalog == nil {
+		return nil, missingClient("Catalog")
+	}
+	datacenters, err := catalog.Datacenters()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := w.watchContext(ctx)
+	out := make(chan DCKVPair)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	// watched are the running watches by datacenter, a watch removes itself once it ended
+	watched := make(map[string]*dcWatch)
+
+	startWatch := func(dc string) {
+		dcCtx, dcCancel := context.WithCancel(ctx)
+		// the datacenter is applied last so an option of the caller can't override it
+		dcOpts := append(opts[:len(opts):len(opts)], inDatacenter(dc))
+		pairs, err := w.WatchKey(dcCtx, key, dcOpts...)
+		if err != nil {
+			dcCancel()
+			return
+		}
+		watch := &dcWatch{cancel: dcCancel}
+		watched[dc] = watch
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// read until the watch closed pairs, also once ctx is done, so its emitter can't block
+			for pair := range pairs {
+				select {
+				case out <- DCKVPair{Datacenter: dc, Pair: pair}:
+				case <-ctx.Done():
+				}
+			}
+
+			dcCancel()
+			mu.Lock()
+			if watched[dc] == watch {
+				delete(watched, dc)
+			}
+			mu.Unlock()
+		}()
+	}
+
+	// refresh starts watches for new datacenters and those whose watch ended and stops the watches of
+	// datacenters that no longer exist
+	refresh := func(datacenters []string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		known := make(map[string]struct{}, len(datacenters))
+		for _, dc := range datacenters {
+			known[dc] = struct{}{}
+			if _, ok := watched[dc]; !ok {
+				startWatch(dc)
+			}
+		}
+		for dc, watch := range watched {
+			if _, ok := known[dc]; !ok {
+				watch.cancel()
+				delete(watched, dc)
+			}
+		}
+	}
+
+	refresh(datacenters)
+
+	// the refresh loop counts as a watch itself so the output channel stays open while it runs
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(datacenterRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				datacenters, err := catalog.Datacenters()
+				if err != nil {
+					continue
+				}
+				refresh(datacenters)
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// dcWatch is a running watch of WatchKeyAllDatacenters in one datacenter
+type dcWatch struct {
+	cancel context.CancelFunc
+}
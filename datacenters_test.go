@@ -0,0 +1,136 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// failingDCKV fails all reads in a datacenter while it is set
+type failingDCKV struct {
+	*watchertest.KV
+
+	mu sync.Mutex
+	dc string
+}
+
+func (kv *failingDCKV) setFailing(dc string) {
+	kv.mu.Lock()
+	kv.dc = dc
+	kv.mu.Unlock()
+}
+
+func (kv *failingDCKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	kv.mu.Lock()
+	failing := kv.dc != "" && q.Datacenter == kv.dc
+	kv.mu.Unlock()
+	if failing {
+		return nil, nil, errors.New("failed")
+	}
+
+	return kv.KV.Get(key, q)
+}
+
+// runningDatacenters returns the sorted datacenters of the watches listed by w that didn't fail
+func runningDatacenters(w *watcher.Watcher) []string {
+	var dcs []string
+	for _, info := range w.List() {
+		if info.Err == nil {
+			dcs = append(dcs, info.Datacenter)
+		}
+	}
+	sort.Strings(dcs)
+	return dcs
+}
+
+func TestWatchKeyAllDatacentersRefresh(t *testing.T) {
+	checkGoroutines(t)
+	defer watcher.SetDatacenterRefreshInterval(10 * time.Millisecond)()
+
+	kv := &failingDCKV{KV: watchertest.NewKV(), dc: "dc2"}
+	kv.Put("key", []byte("value"))
+	catalog := watchertest.NewCatalog("dc1", "dc2")
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv), watcher.WithCatalogClient(catalog))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pairs, err := w.WatchKeyAllDatacenters(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range pairs {
+		}
+	}()
+
+	// the watch of dc2 died and is started again once dc2 can be read
+	waitFor(t, func() bool {
+		return equalStrings(runningDatacenters(w), []string{"dc1"})
+	})
+	kv.setFailing("")
+	waitFor(t, func() bool {
+		return equalStrings(runningDatacenters(w), []string{"dc1", "dc2"})
+	})
+
+	// the watch of a removed datacenter is stopped
+	catalog.SetDatacenters("dc2")
+	waitFor(t, func() bool {
+		return equalStrings(runningDatacenters(w), []string{"dc2"})
+	})
+
+	cancel()
+}
+
+// dcKV holds a separate KV per datacenter
+type dcKV struct {
+	*watchertest.KV
+	dcs map[string]*watchertest.KV
+}
+
+func (kv *dcKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	return kv.dcs[q.Datacenter].Get(key, q)
+}
+
+func TestWatchKeyAllDatacentersIndependent(t *testing.T) {
+	checkGoroutines(t)
+	kv := &dcKV{KV: watchertest.NewKV(), dcs: map[string]*watchertest.KV{
+		"dc1": watchertest.NewKV(),
+		"dc2": watchertest.NewKV(),
+	}}
+	for _, dc := range kv.dcs {
+		dc.Put("key", []byte("v1"))
+	}
+	catalog := watchertest.NewCatalog("dc1", "dc2")
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv), watcher.WithCatalogClient(catalog))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyAllDatacenters(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both datacenters emit the same value
+	seen := map[string]string{}
+	for i := 0; i < 2; i++ {
+		pair := <-pairs
+		seen[pair.Datacenter] = string(pair.Pair.Value)
+	}
+	if seen["dc1"] != "v1" || seen["dc2"] != "v1" {
+		t.Fatalf("got %v, want v1 from both datacenters", seen)
+	}
+
+	// the update in dc1 doesn't suppress the same value in dc2
+	for _, dc := range []string{"dc1", "dc2"} {
+		kv.dcs[dc].Put("key", []byte("v2"))
+		if pair := <-pairs; pair.Datacenter != dc || string(pair.Pair.Value) != "v2" {
+			t.Fatalf("got %s from %s, want v2 from %s", pair.Pair.Value, pair.Datacenter, dc)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestDebounceOverride(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("0"))
+	// the debounce of the Watcher would hold back every emission for an hour
+	w := watcher.New(nil, 10*time.Millisecond, time.Hour, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "key", watcher.WithDebounceOverride(30*time.Millisecond),
+		watcher.WithForceFlushOnSustainedChange(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	// a burst of changes emits only its final value
+	for i := 1; i <= 5; i++ {
+		kv.Put("key", []byte(fmt.Sprint(i)))
+		time.Sleep(5 * time.Millisecond)
+	}
+	pair := <-pairs
+	if string(pair.Pair.Value) != "5" || !pair.Debounced {
+		t.Fatalf("got %s debounced %v, want the debounced 5", pair.Pair.Value, pair.Debounced)
+	}
+	select {
+	case pair := <-pairs:
+		t.Fatalf("got a second emission %s for the burst", pair.Pair.Value)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestDebouncedFlag(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 20*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first load is sent immediately
+	if pair := <-pairs; pair.Debounced {
+		t.Fatal("got a debounced first load, want it immediate")
+	}
+
+	kv.Put("key", []byte("1"))
+	if pair := <-pairs; string(pair.Pair.Value) != "1" || !pair.Debounced {
+		t.Fatalf("got %s debounced %v, want the debounced 1", pair.Pair.Value, pair.Debounced)
+	}
+}
+
+func TestForceFlushOnSustainedChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "enabled", enabled: true},
+		{name: "disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("key", []byte("0"))
+			w := watcher.New(nil, 10*time.Millisecond, 30*time.Millisecond, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKeyWithMeta(ctx, "key", watcher.WithForceFlushOnSustainedChange(tt.enabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+
+			// the key changes faster than the debounce for several windows
+			burst := time.After(200 * time.Millisecond)
+			ticker := time.NewTicker(5 * time.Millisecond)
+			defer ticker.Stop()
+			flushed := 0
+			for i := 1; ; i++ {
+				select {
+				case <-ticker.C:
+					kv.Put("key", []byte(fmt.Sprint(i)))
+					continue
+				case pair := <-pairs:
+					if pair.Debounced {
+						t.Fatalf("got debounced %s during the burst", pair.Pair.Value)
+					}
+					flushed++
+					continue
+				case <-burst:
+				}
+				break
+			}
+
+			if tt.enabled {
+				if flushed == 0 {
+					t.Fatal("got no forced flush during the burst")
+				}
+				return
+			}
+			if flushed != 0 {
+				t.Fatalf("got %d forced flushes with the flush disabled", flushed)
+			}
+			// without the flush the burst is emitted once it settled
+			if pair := <-pairs; !pair.Debounced {
+				t.Fatalf("got immediate %s after the burst, want it debounced", pair.Pair.Value)
+			}
+		})
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	passThrough := func(in <-chan watcher.Event) <-chan watcher.Event {
+		out := make(chan watcher.Event)
+		go func() {
+			defer close(out)
+			for event := range in {
+				out <- event
+			}
+		}()
+		return out
+	}
+	tests := []struct {
+		name      string
+		debouncer func(in <-chan watcher.Event) <-chan watcher.Event
+		want      []string
+	}{
+		{name: "pass-through", debouncer: passThrough, want: []string{"1", "2", "3"}},
+		{name: "default", debouncer: watcher.DefaultDebouncer(30 * time.Millisecond), want: []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("key", []byte("0"))
+			// the built-in debounce of an hour is replaced by the debouncer
+			w := watcher.New(nil, 10*time.Millisecond, time.Hour, watcher.WithKVClient(kv))
+
+			polls := make(chan bool, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", watcher.WithDebouncer(tt.debouncer),
+				watcher.WithOnPollComplete(func(changed bool) {
+					polls <- changed
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+			<-polls
+
+			go func() {
+				for i := 1; i <= 3; i++ {
+					kv.Put("key", []byte(fmt.Sprint(i)))
+					<-polls
+				}
+			}()
+			for _, want := range tt.want {
+				if pair := <-pairs; string(pair.Value) != want {
+					t.Fatalf("got %s, want %s", pair.Value, want)
+				}
+			}
+			select {
+			case pair := <-pairs:
+				t.Fatalf("got unexpected value %s", pair.Value)
+			case <-time.After(60 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is a value read by the query loop of a watch, it is passed to the debouncer set with WithDebouncer
+type Event struct {
+	// Kind is the kind of the watch
+	Kind WatchKind
+	// Target is the watched key or path
+	Target string
+	// Value is the value read from Consul, *consul.KVPair for key watches and consul.KVPairs for tree watches.
+	// A debouncer must only emit values of the same type or nil for the zero value.
+	Value interface{}
+	// Index is the index of the query that read Value
+	Index uint64
+	// ObservedAt is when the query that read Value returned
+	ObservedAt time.Time
+	// Immediate is set for values the built-in debounce emits without delay, like the first value of a watch
+	Immediate bool
+
+	// change is the change of the value, it keeps the query details of an event passed through
+	change interface{}
+}
+
+// WithDebouncer replaces the built-in debounce of a watch with debouncer, so callers can implement their
+// own debounce, coalesce or throttle policy. debouncer is called once when the watch starts with the channel
+// of the values read by the query loop, every value it sends on the returned channel is emitted without
+// further delay. The input channel is closed when the query loop ended, debouncer must then close its channel,
+// after sending what it still wants emitted, and it must keep reading its input until then. The watch only
+// closes its channel once the channel of debouncer is closed. Values are emitted in the order debouncer sends
+// them and Meta.Debounced is never set. WithAdaptiveDebounce and WithForceFlushOnSustainedChange have no effect.
+// DefaultDebouncer returns a debouncer that behaves like the built-in debounce.
+func WithDebouncer(debouncer func(in <-chan Event) <-chan Event) WatchOption {
+	return func(o *watchOptions) {
+		o.debouncer = debouncer
+	}
+}
+
+// DefaultDebouncer returns a debouncer for WithDebouncer that works like the built-in debounce with
+// debounceTime: every event restarts the timer and the last event is emitted once no event arrived for
+// debounceTime. Immediate events and events arriving more than twice debounceTime after the first event
+// of a pending debounce are emitted at once. A pending event is dropped when the input is closed.
+func DefaultDebouncer(debounceTime time.Duration) func(in <-chan Event) <-chan Event {
+	return func(in <-chan Event) <-chan Event {
+		out := make(chan Event)
+		go func() {
+			defer close(out)
+
+			var timer *time.Timer
+			var timerC <-chan time.Time
+			var start time.Time
+			var pending Event
+			stopTimer := func() {
+				if timer != nil {
+					timer.Stop()
+					timerC = nil
+				}
+			}
+			defer stopTimer()
+
+			for {
+				select {
+				case e, ok := <-in:
+					if !ok {
+						return
+					}
+
+					stopTimer()
+					if e.Immediate || (!start.IsZero() && time.Since(start) > 2*debounceTime) {
+						start = time.Time{}
+						out <- e
+						continue
+					}
+
+					if start.IsZero() {
+						start = time.Now()
+					}
+					pending = e
+					timer = time.NewTimer(debounceTime)
+					timerC = timer.C
+				case <-timerC:
+					timerC = nil
+					start = time.Time{}
+					out <- pending
+				}
+			}
+		}()
+
+		return out
+	}
+}
+
+// debounce passes changes through the debouncer of the watch and returns the changes it emits,
+// changes itself if no debouncer is set. The returned channel is closed after the debouncer closed its channel.
+func (r *run[T, E]) debounce(changes <-chan change[T]) <-chan change[T] {
+	if r.o.debouncer == nil {
+		return changes
+	}
+
+	in := make(chan Event)
+	go func() {
+		defer close(in)
+
+		for c := range changes {
+			e := Event{
+				Kind:       r.src.kind,
+				Target:     r.src.target,
+				Value:      c.value,
+				Index:      c.lastIndex,
+				ObservedAt: c.observedAt,
+				Immediate:  c.immediate,
+				change:     c,
+			}
+			// once the watch is done, changes are only drained until poll closed them
+			select {
+			case in <- e:
+			case <-r.ctx.Done():
+			}
+		}
+	}()
+
+	debounced := r.o.debouncer(in)
+	out := make(chan change[T])
+	go func() {
+		defer close(out)
+
+		// emit reads out until it is closed, also after the watch is done
+		for e := range debounced {
+			if c, ok := r.fromEvent(e); ok {
+				out <- c
+			}
+		}
+	}()
+
+	return out
+}
+
+// fromEvent returns the change for an event emitted by the debouncer, false if its value has the wrong type
+func (r *run[T, E]) fromEvent(e Event) (change[T], bool) {
+	c, _ := e.change.(change[T])
+	if e.Value == nil {
+		var zero T
+		c.value = zero
+	} else if value, ok := e.Value.(T); ok {
+		c.value = value
+	} else {
+		r.o.handleError(fmt.Errorf("%w: debouncer emitted %T for a %s watch", ErrInvalidOptions, e.Value, r.src.kind))
+		return change[T]{}, false
+	}
+	c.lastIndex = e.Index
+	c.observedAt = e.ObservedAt
+	c.immediate = true
+
+	return c, true
+}
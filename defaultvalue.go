@@ -0,0 +1,27 @@
+package watcher
+
+import (
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WithDefaultValue makes a watch of a single key emit a key value pair with value whenever the key
+// doesn't exist, and the live pair while it does, so consumers never receive nil. Creating the key
+// switches to the live value, deleting it emits the default again. A default pair has no indexes set,
+// it is reported by IsDefault and by Meta.Default. WatchKeyEvents reports it as not existing, and
+// WithWaitForExistence still waits for the key to be created first. Tree watches ignore the option.
+func WithDefaultValue(value []byte) WatchOption {
+	return func(o *watchOptions) {
+		o.defaultValue = append([]byte{}, value...)
+	}
+}
+
+// IsDefault reports whether pair is not stored in Consul but was emitted for a missing key, i.e. the
+// default value of WithDefaultValue or the contents of a fallback file
+func IsDefault(pair *consul.KVPair) bool {
+	return pair != nil && pair.CreateIndex == 0
+}
+
+// defaultPair returns a new key value pair of key with the default value
+func (o *watchOptions) defaultPair(key string) *consul.KVPair {
+	return &consul.KVPair{Key: key, Value: append([]byte{}, o.defaultValue...)}
+}
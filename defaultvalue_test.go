@@ -0,0 +1,46 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestDefaultValue(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "feature", watcher.WithDefaultValue([]byte("off")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		value  string
+		dflt   bool
+	}{
+		{name: "missing", value: "off", dflt: true},
+		{name: "created", change: func() { kv.Put("feature", []byte("on")) }, value: "on"},
+		{name: "deleted", change: func() { kv.Delete("feature") }, value: "off", dflt: true},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		pair := <-pairs
+		if pair.Pair == nil || pair.Pair.Key != "feature" || string(pair.Pair.Value) != step.value {
+			t.Fatalf("%s: got %v, want feature with %s", step.name, pair.Pair, step.value)
+		}
+		if pair.Default != step.dflt || watcher.IsDefault(pair.Pair) != step.dflt {
+			t.Fatalf("%s: got default %v, want %v", step.name, pair.Default, step.dflt)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// deliveryQueueSize is the number of callbacks a single watch queues while its callback is running
+const deliveryQueueSize = 16
+
+// WithDeliveryWorkers runs the callbacks of callback based watches like WatchTreeApply asynchronously on a pool
+// of n workers shared by all watches of the Watcher, so a slow callback doesn't stall querying Consul.
+// The callbacks of a single watch still run one after another in order. Each watch queues up to 16 callbacks,
+// when its queue is full the watch blocks until the callback caught up. With WithBackpressure the queue holds
+// the size of the option and a full queue is handled by its policy, dropped callbacks are never called.
+func WithDeliveryWorkers(n int) Option {
+	return func(w *Watcher) {
+		if n > 0 {
+			w.deliveryWorkers = make(semaphore, n)
+		}
+	}
+}
+
+// deliveries runs the callbacks of a single watch in order, on a worker of the pool if one is configured
+type deliveries struct {
+	workers semaphore
+	// ready is signalled when a callback was queued or the deliveries were closed
+	ready chan struct{}
+	// space is signalled when a callback was taken from the queue
+	space chan struct{}
+	done  chan struct{}
+
+	mu     sync.Mutex
+	queue  *emitBuffer[func()]
+	closed bool
+}
+
+// newDeliveries returns the deliveries of a watch with options o, without workers callbacks run on the
+// calling goroutine
+func (w *Watcher) newDeliveries(o *watchOptions) *deliveries {
+	d := &deliveries{workers: w.deliveryWorkers}
+	if d.workers == nil {
+		return d
+	}
+
+	size := deliveryQueueSize
+	if o.bufferSize > 0 {
+		size = o.bufferSize
+	}
+	d.queue = &emitBuffer[func()]{policy: o.backpressure, size: size}
+	d.ready = make(chan struct{}, 1)
+	d.space = make(chan struct{}, 1)
+	d.done = make(chan struct{})
+	go d.run()
+
+	return d
+}
+
+// run calls the queued callbacks until the deliveries are closed and the queue is empty
+func (d *deliveries) run() {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		item, ok := d.queue.head()
+		if ok {
+			d.queue.pop()
+		}
+		closed := d.closed
+		d.mu.Unlock()
+
+		if !ok {
+			if closed {
+				return
+			}
+			<-d.ready
+			continue
+		}
+
+		signal(d.space)
+		// acquire can't fail without a deadline
+		_ = d.workers.acquire(context.Background())
+		item.c.value()
+		d.workers.release()
+	}
+}
+
+// deliver runs fn or queues it according to the backpressure policy. With Block it waits for a free
+// place in the queue until ctx is done and drops fn then.
+func (d *deliveries) deliver(ctx context.Context, fn func()) {
+	if d.queue == nil {
+		fn()
+		return
+	}
+
+	item := queued[func()]{c: change[func()]{value: fn}}
+	for {
+		d.mu.Lock()
+		ok := d.queue.push(item)
+		d.mu.Unlock()
+		if ok {
+			signal(d.ready)
+			return
+		}
+
+		select {
+		case <-d.space:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close waits until all queued callbacks have run, no callback may be delivered afterwards
+func (d *deliveries) close() {
+	if d.queue == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	signal(d.ready)
+	<-d.done
+}
+
+// signal notifies a waiter on ch without blocking, ch has a buffer of one so a signal isn't lost
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestDeliveryBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy watcher.BackpressurePolicy
+		// second is the last created key of the second call of apply
+		second string
+	}{
+		{name: "drop newest", policy: watcher.DropNewest, second: "app/1"},
+		{name: "drop oldest", policy: watcher.DropOldest, second: "app/3"},
+		{name: "coalesce", policy: watcher.Coalesce, second: "app/3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("app/0", []byte("0"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv), watcher.WithDeliveryWorkers(1))
+
+			gate := make(chan struct{})
+			var mu sync.Mutex
+			var calls []string
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := w.WatchTreeApply(ctx, "app/", func(created, _, _ consul.KVPairs) error {
+				mu.Lock()
+				calls = append(calls, created[len(created)-1].Key)
+				first := len(calls) == 1
+				mu.Unlock()
+				if first {
+					<-gate
+				}
+				return nil
+			}, watcher.WithBackpressure(tt.policy, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the watch keeps emitting while the first call of apply is stuck
+			for i := 0; i <= 3; i++ {
+				if i > 0 {
+					kv.Put(fmt.Sprintf("app/%d", i), []byte("value"))
+				}
+				waitFor(t, func() bool {
+					infos := w.List()
+					return len(infos) == 1 && infos[0].Emissions == uint64(i+1)
+				})
+			}
+			close(gate)
+			waitFor(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(calls) == 2
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if calls[1] != tt.second {
+				t.Fatalf("got second call with %s, want %s", calls[1], tt.second)
+			}
+		})
+	}
+}
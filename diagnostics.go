@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// transportTimeoutThreshold is the number of consecutive blocking queries that have to time out
+// before a too short transport timeout is reported
+const transportTimeoutThreshold = 3
+
+// ErrTransportTimeout is reported to the error handler if blocking queries keep timing out before their wait time,
+// which means that the timeout of the HTTP client used by the Consul client is shorter than the wait time
+var ErrTransportTimeout = errors.New("transport timeout shorter than wait time")
+
+// transportTimeouts detects a transport timeout shorter than the wait time. The Consul client doesn't
+// expose its HTTP client, so the timeout is inferred from blocking queries failing early with timeouts.
+type transportTimeouts struct {
+	count int
+}
+
+// observe records the result of a query that ran for elapsed and returns a diagnostic error
+// once the threshold of consecutive early timeouts is reached
+func (t *transportTimeouts) observe(opts *consul.QueryOptions, elapsed time.Duration, err error) error {
+	// only blocking queries wait long enough to hit the transport timeout
+	if opts.WaitIndex == 0 {
+		return nil
+	}
+
+	var netErr net.Error
+	if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() || elapsed >= opts.WaitTime {
+		t.count = 0
+		return nil
+	}
+
+	t.count++
+	if t.count != transportTimeoutThreshold {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d blocking queries timed out after %s with a wait time of %s, "+
+		"raise the HTTP client timeout above the wait time",
+		ErrTransportTimeout, t.count, elapsed.Round(time.Millisecond), opts.WaitTime)
+}
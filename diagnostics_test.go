@@ -0,0 +1,122 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// timeoutError is the net.Error of an HTTP client timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "Client.Timeout exceeded while awaiting headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// timeoutKV lets every blocking query time out after 5ms like a transport with a short timeout
+type timeoutKV struct {
+	*watchertest.KV
+	timeouts chan struct{}
+}
+
+func (kv *timeoutKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if q.WaitIndex == 0 {
+		return kv.KV.Get(key, q)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	select {
+	case kv.timeouts <- struct{}{}:
+	default:
+	}
+	return nil, nil, timeoutError{}
+}
+
+func TestTransportTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		waitTime time.Duration
+		want     bool
+	}{
+		{name: "shorter than wait time", waitTime: time.Minute, want: true},
+		{name: "after wait time", waitTime: time.Millisecond, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := &timeoutKV{KV: watchertest.NewKV(), timeouts: make(chan struct{}, 10)}
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			reported := make(chan error, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err := w.WatchKey(ctx, "key", watcher.WithWaitTime(tt.waitTime),
+				watcher.WithErrorHandler(func(err error) {
+					if errors.Is(err, watcher.ErrTransportTimeout) {
+						reported <- err
+					}
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the diagnostic is reported after 3 consecutive early timeouts
+			for i := 0; i < 4; i++ {
+				<-kv.timeouts
+			}
+			select {
+			case err := <-reported:
+				if !tt.want {
+					t.Fatalf("got %v for timeouts after the wait time", err)
+				}
+			default:
+				if tt.want {
+					t.Fatal("got no ErrTransportTimeout for repeated early timeouts")
+				}
+			}
+		})
+	}
+}
+
+// slowKV delays every query by delay
+type slowKV struct {
+	*watchertest.KV
+	delay time.Duration
+}
+
+func (kv slowKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	time.Sleep(kv.delay)
+	return kv.KV.Get(key, q)
+}
+
+func TestQueryLatencyHandler(t *testing.T) {
+	checkGoroutines(t)
+	kv := slowKV{KV: watchertest.NewKV(), delay: 40 * time.Millisecond}
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	type latency struct {
+		d       time.Duration
+		changed bool
+	}
+	latencies := make(chan latency, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithQueryLatencyHandler(func(d time.Duration, changed bool) {
+		latencies <- latency{d: d, changed: changed}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	if l := <-latencies; l.d < 40*time.Millisecond || l.d > time.Second || !l.changed {
+		t.Fatalf("got %s changed %v, want about the mocked 40ms and a change", l.d, l.changed)
+	}
+}
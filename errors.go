@@ -0,0 +1,150 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+var (
+	// ErrPermissionDenied is reported to the error handler if the ACL token has no read access to a watched key
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrRateLimited is reported to the error handler if Consul rejected a query because of rate limiting
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInvalidOptions is returned when starting a watch with conflicting options
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrNoLeader is returned by Ping if the Consul cluster currently has no leader
+	ErrNoLeader = errors.New("no cluster leader")
+	// ErrResponseTooLarge is reported to the error handler if Consul rejected a query because its response
+	// exceeded a size limit, see WithAutoSplitOnOversize
+	ErrResponseTooLarge = errors.New("response too large")
+)
+
+// RetryError is passed to the error handler for an error after which the query is retried. It describes the
+// sequence of consecutive failures of the watch, e.g. to escalate once a watch failed for too long, and unwraps
+// to the error, so errors.Is and errors.As work as for the error itself.
+type RetryError struct {
+	Err error
+	// Attempt is the number of consecutive failed queries including this one, starting at 1
+	Attempt int
+	// Elapsed is the time since the first failed query of the sequence was started
+	Elapsed time.Duration
+	// NextBackoff is the time the watch waits before the next query
+	NextBackoff time.Duration
+}
+
+// Error returns the message of the error
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// checkContext returns ErrInvalidOptions for a nil ctx, which would otherwise panic deep inside a watch,
+// and the error of ctx if it is already done, so a watch isn't started only to end immediately
+func checkContext(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("%w: nil context", ErrInvalidOptions)
+	}
+
+	return ctx.Err()
+}
+
+// classifiedError is an error returned by Consul that matches one of the typed errors of this package
+// with errors.Is. The original error stays in the chain, e.g. for errors.As with a consul.StatusError.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+// Is reports whether target is the typed error of e
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the original error
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// classifyError wraps errors returned by Consul into the typed errors of this package
+// so they can be matched with errors.Is, other errors are returned unchanged
+func classifyError(err error) error {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	switch {
+	case isPermissionDenied(err):
+		return &classifiedError{kind: ErrPermissionDenied, err: err}
+	case isRateLimited(err):
+		return &classifiedError{kind: ErrRateLimited, err: err}
+	case isOversized(err):
+		return &classifiedError{kind: ErrResponseTooLarge, err: err}
+	}
+
+	return err
+}
+
+// isRetryable reports whether a query should be retried after err, in addition to the
+// errors Consul considers retryable this includes rate limiting
+func isRetryable(err error) bool {
+	return consul.IsRetryableError(err) || isRateLimited(err)
+}
+
+// isRateLimited checks for the 429 response sent when Consul applies request rate limits
+func isRateLimited(err error) bool {
+	var statusErr consul.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests
+	}
+
+	return strings.Contains(err.Error(), "Unexpected response code: 429")
+}
+
+// retryAfter returns the retry hint of err if it has one. The Consul client doesn't expose the
+// Retry-After header, errors of other clients can provide it with a RetryAfter method.
+func retryAfter(err error) (time.Duration, bool) {
+	var hint interface {
+		RetryAfter() time.Duration
+	}
+	if errors.As(err, &hint) && hint.RetryAfter() > 0 {
+		return hint.RetryAfter(), true
+	}
+
+	return 0, false
+}
+
+// isOversized checks for the 413 response Consul sends for a response exceeding a size limit.
+// Errors of other clients only match with the exact message of the Consul client for that response.
+func isOversized(err error) bool {
+	var statusErr consul.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusRequestEntityTooLarge
+	}
+
+	return strings.Contains(err.Error(), "Unexpected response code: 413 (")
+}
+
+// isPermissionDenied checks for the 403 response Consul sends when ACLs deny access
+func isPermissionDenied(err error) bool {
+	var statusErr consul.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusForbidden
+	}
+
+	return strings.Contains(err.Error(), "Permission denied")
+}
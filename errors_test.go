@@ -0,0 +1,256 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestClassifiedErrorChain(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		want error
+	}{
+		{name: "permission denied", code: http.StatusForbidden, want: watcher.ErrPermissionDenied},
+		{name: "too large", code: http.StatusRequestEntityTooLarge, want: watcher.ErrResponseTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.SetError(consul.StatusError{Code: tt.code, Body: "denied"})
+
+			errs := make(chan error, 10)
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+			pairs, err := w.WatchKey(context.Background(), "key", watcher.WithErrorHandler(func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range pairs {
+			}
+
+			err = <-errs
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var statusErr consul.StatusError
+			if !errors.As(err, &statusErr) || statusErr.Code != tt.code {
+				t.Fatalf("got %v, want a consul.StatusError with code %d in the chain", err, tt.code)
+			}
+		})
+	}
+}
+
+// rateLimitError is a 429 response with a retry hint
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e rateLimitError) Error() string {
+	return "Unexpected response code: 429 (rate limit exceeded)"
+}
+
+func (e rateLimitError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func TestRateLimited(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	kv.SetError(rateLimitError{retryAfter: 30 * time.Millisecond})
+
+	errs := make(chan error, 10)
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-errs
+	if !errors.Is(err, watcher.ErrRateLimited) {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+	var retryErr *watcher.RetryError
+	if !errors.As(err, &retryErr) || retryErr.NextBackoff != 30*time.Millisecond {
+		t.Fatalf("got %v, want a retry after the hinted 30ms", err)
+	}
+
+	// the watch keeps retrying and loads the key once the rate limit is lifted
+	kv.SetError(nil)
+	if pair := <-pairs; pair == nil || string(pair.Value) != "value" {
+		t.Fatalf("got %v, want value", pair)
+	}
+}
+
+func TestRecoverUnchanged(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	polls := make(chan bool, 10)
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key",
+		watcher.WithOnPollComplete(func(changed bool) {
+			polls <- changed
+		}),
+		watcher.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+	<-polls
+
+	// the reconnect after the error resets the index and reads the unchanged value again
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	<-errs
+	kv.SetError(nil)
+	if changed := <-polls; changed {
+		t.Fatal("got a change for the unchanged value after recovering")
+	}
+	select {
+	case pair := <-pairs:
+		t.Fatalf("got emission %s of the unchanged value after recovering", pair.Value)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("key", []byte("new"))
+	if pair := <-pairs; string(pair.Value) != "new" {
+		t.Fatalf("got %s, want new", pair.Value)
+	}
+}
+
+func TestProbeOnStart(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.SetError(consul.StatusError{Code: http.StatusForbidden, Body: "ACL not found"})
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	_, err := w.WatchKey(context.Background(), "key", watcher.WithProbeOnStart())
+	if !errors.Is(err, watcher.ErrPermissionDenied) {
+		t.Fatalf("got %v, want ErrPermissionDenied from the call", err)
+	}
+
+	// a retryable error doesn't fail the call
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithProbeOnStart())
+	if err != nil {
+		t.Fatalf("got %v for a retryable error, want the watch started", err)
+	}
+	kv.SetError(nil)
+	if pair := <-pairs; pair != nil {
+		t.Fatalf("got %v, want nil for the missing key", pair)
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	errs := make(chan *watcher.RetryError, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithErrorHandler(func(err error) {
+		var retryErr *watcher.RetryError
+		if errors.As(err, &retryErr) {
+			select {
+			case errs <- retryErr:
+			default:
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attempt and elapsed increase across consecutive errors
+	var prev *watcher.RetryError
+	for attempt := 1; attempt <= 3; attempt++ {
+		retryErr := <-errs
+		if retryErr.Attempt != attempt || retryErr.NextBackoff <= 0 {
+			t.Fatalf("got attempt %d backoff %s, want attempt %d", retryErr.Attempt, retryErr.NextBackoff, attempt)
+		}
+		if prev != nil && retryErr.Elapsed <= prev.Elapsed {
+			t.Fatalf("got elapsed %s after %s, want it increasing", retryErr.Elapsed, prev.Elapsed)
+		}
+		prev = retryErr
+	}
+
+	// a successful query resets the sequence
+	kv.SetError(nil)
+	<-pairs
+	for len(errs) > 0 {
+		<-errs
+	}
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	if retryErr := <-errs; retryErr.Attempt != 1 {
+		t.Fatalf("got attempt %d after a success, want 1", retryErr.Attempt)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	errs := make(chan error, 10)
+	sub, err := w.SubscribeKey(context.Background(), "key", watcher.WithFailFast(),
+		watcher.WithErrorHandler(func(err error) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Updates()
+
+	// a single retryable error ends the watch
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	for range sub.Updates() {
+	}
+	<-sub.Done()
+	if err := sub.Err(); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("got %v, want the retryable error", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors passed to the handler, want 1", len(errs))
+	}
+
+	_, err = w.WatchKey(context.Background(), "key", watcher.WithFailFast(), watcher.WithNeverGiveUp())
+	if !errors.Is(err, watcher.ErrInvalidOptions) {
+		t.Fatalf("got %v, want ErrInvalidOptions with WithNeverGiveUp", err)
+	}
+}
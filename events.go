@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"context"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// KeyEvent is the state of a watched key
+type KeyEvent struct {
+	Key string
+	// Pair is the current key value pair, nil if the key does not exist
+	Pair *consul.KVPair
+	// Exists is true if the key exists, a key may exist with an empty value. Creating a key with an empty
+	// value and deleting it are reported like any other create and delete.
+	Exists bool
+	// Deleted is true if the key existed before and was deleted
+	Deleted bool
+}
+
+// WatchKeyEvents watches for changes to a key and emits its existence state.
+// An event with Exists set is emitted when the key is created and on every update,
+// an event without Exists when a previously existing key is deleted.
+// A key that does not exist yet is not reported until it is created, also not after reconnects,
+// unless WithEmitAbsent is set.
+func (w *Watcher) WatchKeyEvents(ctx context.Context, key string, opts ...WatchOption) (<-chan KeyEvent, error) {
+	o := w.newWatchOptions(opts)
+	pairs, err := w.WatchKey(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan KeyEvent)
+	go func() {
+		defer close(out)
+
+		existed := false
+		first := true
+		for pair := range pairs {
+			exists := pair != nil && !IsDefault(pair)
+			if !exists && !existed && !(first && o.emitAbsent) {
+				first = false
+				continue
+			}
+			first = false
+			deleted := existed && !exists
+			existed = exists
+
+			select {
+			case out <- KeyEvent{Key: key, Pair: pair, Exists: exists, Deleted: deleted}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, nil
+}
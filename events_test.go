@@ -0,0 +1,129 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchKeyEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		emitAbsent bool
+	}{
+		{name: "default"},
+		{name: "emit absent", emitAbsent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			var opts []watcher.WatchOption
+			if tt.emitAbsent {
+				opts = append(opts, watcher.WithEmitAbsent())
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := w.WatchKeyEvents(ctx, "key", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// absent
+			if tt.emitAbsent {
+				if event := <-events; event.Exists || event.Deleted || event.Pair != nil {
+					t.Fatalf("got %+v, want an absent key", event)
+				}
+			}
+
+			// present with an empty value
+			kv.Put("key", nil)
+			if event := <-events; !event.Exists || event.Deleted || event.Pair == nil {
+				t.Fatalf("got %+v, want an existing key", event)
+			}
+
+			// deleted
+			kv.Delete("key")
+			if event := <-events; event.Exists || !event.Deleted {
+				t.Fatalf("got %+v, want a deleted key", event)
+			}
+		})
+	}
+}
+
+func TestWaitForExistence(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	polls := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithWaitForExistence(),
+		watcher.WithOnPollComplete(func(changed bool) {
+			polls <- changed
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-polls
+
+	// writes to other keys wake up the query a few cycles while the key is still missing
+	for i := 0; i < 3; i++ {
+		kv.Put("other", []byte(fmt.Sprint(i)))
+		<-polls
+	}
+	select {
+	case pair := <-pairs:
+		t.Fatalf("got %v before the key was created", pair)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("key", []byte("value"))
+	if pair := <-pairs; pair == nil || string(pair.Value) != "value" {
+		t.Fatalf("got %v, want value", pair)
+	}
+}
+
+func TestWatchKeyEmptyValue(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; pair != nil {
+		t.Fatalf("got %v, want nil for the missing key", pair)
+	}
+
+	// missing and empty are distinct states in both directions
+	steps := []struct {
+		name   string
+		change func()
+		exists bool
+		value  string
+	}{
+		{name: "created empty", change: func() { kv.Put("key", nil) }, exists: true},
+		{name: "deleted", change: func() { kv.Delete("key") }},
+		{name: "created", change: func() { kv.Put("key", []byte("value")) }, exists: true, value: "value"},
+		{name: "emptied", change: func() { kv.Put("key", nil) }, exists: true},
+	}
+	for _, step := range steps {
+		step.change()
+		pair := <-pairs
+		if (pair != nil) != step.exists || (pair != nil && string(pair.Value) != step.value) {
+			t.Fatalf("%s: got %v, want exists %v with %q", step.name, pair, step.exists, step.value)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package watcher
+
+import "time"
+
+// SetSplitRetryInterval sets the interval after which split trees list the whole tree again
+// and returns a func restoring the previous interval
+func SetSplitRetryInterval(interval time.Duration) func() {
+	previous := splitRetryInterval
+	splitRetryInterval = interval
+	return func() {
+		splitRetryInterval = previous
+	}
+}
+
+// SetDatacenterRefreshInterval sets the interval in which WatchKeyAllDatacenters refreshes the datacenters
+// and returns a func restoring the previous interval
+func SetDatacenterRefreshInterval(interval time.Duration) func() {
+	previous := datacenterRefreshInterval
+	datacenterRefreshInterval = interval
+	return func() {
+		datacenterRefreshInterval = previous
+	}
+}
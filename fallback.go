@@ -0,0 +1,38 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// WithFallbackFile makes a watch of a single key emit the contents of a local file as the value of the key if
+// its first query fails with a retryable error, so a service can start while Consul is unavailable. The fallback
+// is emitted once with Meta.Fallback set and replaced by the live value on the first successful query. Errors
+// reading the file are passed to the error handler. Tree watches ignore the option.
+func WithFallbackFile(path string) WatchOption {
+	return func(o *watchOptions) {
+		o.fallbackFile = path
+	}
+}
+
+// fallbackChange returns the change with the contents of the fallback file of the watch,
+// false if no fallback is configured or the file can't be read
+func (r *run[T, E]) fallbackChange() (change[T], bool) {
+	if r.o.fallbackFile == "" || r.src.fallback == nil {
+		return change[T]{}, false
+	}
+
+	data, err := os.ReadFile(r.o.fallbackFile)
+	if err != nil {
+		r.o.handleError(fmt.Errorf("read fallback file: %w", err))
+		return change[T]{}, false
+	}
+
+	return change[T]{
+		value:      r.src.fallback(data),
+		observedAt: time.Now(),
+		fallback:   true,
+		immediate:  true,
+	}, true
+}
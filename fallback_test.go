@@ -0,0 +1,46 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestFallbackFile(t *testing.T) {
+	checkGoroutines(t)
+	file := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(file, []byte("local"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kv := watchertest.NewKV()
+	kv.Put("config", []byte("live"))
+	kv.SetError(errors.New("Unexpected response code: 500 (No cluster leader)"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "config", watcher.WithFallbackFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is emitted while Consul is unavailable
+	pair := <-pairs
+	if pair.Pair == nil || pair.Pair.Key != "config" || string(pair.Pair.Value) != "local" || !pair.Fallback {
+		t.Fatalf("got %+v, want the fallback local", pair.Pair)
+	}
+
+	// the live value replaces it once Consul recovered
+	kv.SetError(nil)
+	pair = <-pairs
+	if pair.Pair == nil || string(pair.Pair.Value) != "live" || pair.Fallback {
+		t.Fatalf("got %+v fallback %v, want the live value", pair.Pair, pair.Fallback)
+	}
+}
@@ -0,0 +1,72 @@
+package watcher
+
+import "time"
+
+// WithFlapSuppression coalesces a key that is rapidly created and deleted, e.g. an ephemeral lock key. Once the
+// existence of the key changes compared to the last emission, changes are held back until none arrived for window,
+// then only the final state is emitted. If the key ends up in the state that was emitted last, nothing is emitted.
+// Unlike the debounce this applies to the first change of a transition as well and is not cut short by the forced
+// flush. It only applies to watches of a single key.
+func WithFlapSuppression(window time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.flapWindow = window
+	}
+}
+
+// flapGuard tracks the existence transitions of emitted values for the flap suppression,
+// it is only used on the emitter goroutine
+type flapGuard[T any] struct {
+	window   time.Duration
+	exists   func(T) bool
+	identity func(T) string
+
+	// suppressing is set while a transition is held back
+	suppressing bool
+	sent        bool
+	sentExists  bool
+	sentID      string
+}
+
+// newFlapGuard returns the flap guard of a watch, it is disabled without window or for sources without existence
+func newFlapGuard[T any](window time.Duration, src source[T]) *flapGuard[T] {
+	if src.exists == nil {
+		window = 0
+	}
+
+	return &flapGuard[T]{window: window, exists: src.exists, identity: src.identity}
+}
+
+// hold reports whether value has to be held back for the flap window because it changes the existence
+// compared to the last emission or arrived while a transition is held back
+func (f *flapGuard[T]) hold(value T) bool {
+	if f.window <= 0 || !f.sent {
+		return false
+	}
+
+	if f.suppressing || f.exists(value) != f.sentExists {
+		f.suppressing = true
+	}
+	return f.suppressing
+}
+
+// settled is called when the timer for value fired and reports whether value ends a held back
+// transition in the state that was emitted last, so it must not be emitted
+func (f *flapGuard[T]) settled(value T) bool {
+	held := f.suppressing
+	f.suppressing = false
+
+	return held && f.identity != nil && f.identity(value) == f.sentID
+}
+
+// record is called after value was emitted
+func (f *flapGuard[T]) record(value T) {
+	if f.window <= 0 {
+		return
+	}
+
+	f.sent = true
+	f.sentExists = f.exists(value)
+	if f.identity != nil {
+		f.sentID = f.identity(value)
+	}
+}
@@ -0,0 +1,45 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestFlapSuppression(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "lock", watcher.WithFlapSuppression(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; pair != nil {
+		t.Fatalf("got %v, want nil for the missing key", pair)
+	}
+
+	// a key created and deleted within the window ends up missing as emitted last
+	kv.Put("lock", []byte("a"))
+	time.Sleep(10 * time.Millisecond)
+	kv.Delete("lock")
+	time.Sleep(10 * time.Millisecond)
+
+	// a key that stays is emitted once with its final value after the window
+	kv.Put("lock", []byte("b"))
+	time.Sleep(10 * time.Millisecond)
+	kv.Put("lock", []byte("c"))
+	start := time.Now()
+	pair := <-pairs
+	if pair == nil || string(pair.Value) != "c" {
+		t.Fatalf("got %v, want c", pair)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("got emission after %s, want it held back for the window", elapsed)
+	}
+}
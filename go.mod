@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/cenkalti/backoff/v4 v4.2.0
 	github.com/hashicorp/consul/api v1.13.1
+	go.uber.org/goleak v1.2.1
 )
 
 require (
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// treeHash returns a hash over the keys, values and modify indexes of pairs,
+// independent of the order the pairs are returned in
+func treeHash(pairs consul.KVPairs) string {
+	sorted := make(consul.KVPairs, len(pairs))
+	copy(sorted, pairs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	h := sha256.New()
+	var buf [8]byte
+	for _, pair := range sorted {
+		if pair == nil {
+			continue
+		}
+
+		// length prefixes keep key and value boundaries unambiguous
+		binary.BigEndian.PutUint64(buf[:], uint64(len(pair.Key)))
+		h.Write(buf[:])
+		h.Write([]byte(pair.Key))
+		binary.BigEndian.PutUint64(buf[:], pair.ModifyIndex)
+		h.Write(buf[:])
+		binary.BigEndian.PutUint64(buf[:], uint64(len(pair.Value)))
+		h.Write(buf[:])
+		h.Write(pair.Value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pairHash returns a hash over the key, value and modify index of pair, a missing key has the hash of an empty tree
+func pairHash(pair *consul.KVPair) string {
+	return treeHash(consul.KVPairs{pair})
+}
+
+// treeIndexHash returns a hash over the keys and modify indexes of pairs independent of their order,
+// it doesn't read the values
+func treeIndexHash(pairs consul.KVPairs) string {
+	sorted := make(consul.KVPairs, len(pairs))
+	copy(sorted, pairs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	h := sha256.New()
+	var buf [8]byte
+	for _, pair := range sorted {
+		if pair == nil {
+			continue
+		}
+
+		binary.BigEndian.PutUint64(buf[:], uint64(len(pair.Key)))
+		h.Write(buf[:])
+		h.Write([]byte(pair.Key))
+		binary.BigEndian.PutUint64(buf[:], pair.ModifyIndex)
+		h.Write(buf[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pairIdentity returns a hash over the modify index and value of pair
+func pairIdentity(pair *consul.KVPair) string {
+	h := sha256.New()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], pair.ModifyIndex)
+	h.Write(buf[:])
+	h.Write(pair.Value)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// valueIdentity returns a hash over the value of pair only
+func valueIdentity(pair *consul.KVPair) string {
+	sum := sha256.Sum256(pair.Value)
+	return hex.EncodeToString(sum[:])
+}
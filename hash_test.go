@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"fmt"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// largeTree returns a tree of n keys with values of size bytes
+func largeTree(n, size int) consul.KVPairs {
+	pairs := make(consul.KVPairs, n)
+	for i := range pairs {
+		pairs[i] = &consul.KVPair{
+			Key:         fmt.Sprintf("app/%06d", n-i),
+			Value:       make([]byte, size),
+			ModifyIndex: uint64(i + 1),
+		}
+	}
+	return pairs
+}
+
+func TestTreeIdentity(t *testing.T) {
+	base := consul.KVPairs{
+		{Key: "app/a", Value: []byte("1"), ModifyIndex: 1},
+		{Key: "app/b", Value: []byte("2"), ModifyIndex: 2},
+	}
+	tests := []struct {
+		name string
+		tree consul.KVPairs
+		// sameValues and sameIndex are whether the tree has the identity of base with each mode
+		sameValues, sameIndex bool
+	}{
+		{
+			name:       "reordered",
+			tree:       consul.KVPairs{base[1], base[0]},
+			sameValues: true,
+			sameIndex:  true,
+		},
+		{
+			name: "modify index",
+			tree: consul.KVPairs{base[0], {Key: "app/b", Value: []byte("2"), ModifyIndex: 3}},
+		},
+		{
+			name:      "value at the same index",
+			tree:      consul.KVPairs{base[0], {Key: "app/b", Value: []byte("3"), ModifyIndex: 2}},
+			sameIndex: true,
+		},
+		{
+			name: "deleted",
+			tree: consul.KVPairs{base[0]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := treeHash(tt.tree) == treeHash(base); same != tt.sameValues {
+				t.Errorf("got same identity %v with TreeIdentityValues, want %v", same, tt.sameValues)
+			}
+			if same := treeIndexHash(tt.tree) == treeIndexHash(base); same != tt.sameIndex {
+				t.Errorf("got same identity %v with TreeIdentityIndex, want %v", same, tt.sameIndex)
+			}
+		})
+	}
+}
+
+func BenchmarkTreeIdentity(b *testing.B) {
+	for _, size := range []int{64, 4096} {
+		tree := largeTree(10000, size)
+		b.Run(fmt.Sprintf("values/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				treeHash(tree)
+			}
+		})
+		b.Run(fmt.Sprintf("index/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				treeIndexHash(tree)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHotKey is reported to the error handler if the blocking query of a watch returned with a new
+// index more often than the hot key threshold allows, which usually means a writer rewrites the key constantly
+var ErrHotKey = errors.New("hot key")
+
+// WithHotKeyThreshold reports ErrHotKey to the error handler if the blocking query of the watch returned with
+// a new index more than n times within any interval of per, the window slides with every wake up. After a report
+// the next one follows at the earliest per later while the key stays hot. Each report names the watched key or path.
+// Combine it with WithAdaptiveDebounce to coalesce the emissions of a hot key.
+func WithHotKeyThreshold(n int, per time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.hotKeyLimit = n
+		o.hotKeyWindow = per
+	}
+}
+
+// hotKey detects too many wake ups of a blocking query within a sliding window, it is only used on the poll
+// goroutine
+type hotKey struct {
+	limit  int
+	window time.Duration
+	// wakeups is a ring of the times of the last limit+1 wake ups, next is the index of the oldest
+	wakeups []time.Time
+	next    int
+	// reported is when ErrHotKey was reported last
+	reported time.Time
+}
+
+// observe records a wake up at now and returns ErrHotKey for target if more than limit wake ups happened
+// within the window ending at now, it is reported at most once per window
+func (h *hotKey) observe(now time.Time, target string) error {
+	if h.limit <= 0 || h.window <= 0 {
+		return nil
+	}
+
+	if len(h.wakeups) <= h.limit {
+		h.wakeups = append(h.wakeups, now)
+		if len(h.wakeups) <= h.limit {
+			return nil
+		}
+	} else {
+		h.wakeups[h.next] = now
+		h.next = (h.next + 1) % len(h.wakeups)
+	}
+
+	// the ring holds limit+1 wake ups, the key is hot if the oldest of them is within the window
+	oldest := h.wakeups[h.next]
+	if now.Sub(oldest) >= h.window {
+		return nil
+	}
+	if !h.reported.IsZero() && now.Sub(h.reported) < h.window {
+		return nil
+	}
+
+	h.reported = now
+	return fmt.Errorf("%w: %s changed more than %d times within %s", ErrHotKey, target, h.limit, h.window)
+}
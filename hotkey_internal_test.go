@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHotKeySlidingWindow(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	tests := []struct {
+		name     string
+		wakeups  []int
+		reported []int
+	}{
+		{
+			// fixed windows starting at 0 and 1000 each see 5 wake ups, but 950 to 1010 are 6 within a second
+			name:     "burst straddling a window boundary",
+			wakeups:  []int{0, 950, 960, 970, 980, 1000, 1010, 1020, 1030, 1040},
+			reported: []int{1010},
+		},
+		{
+			name:    "steady rate below the limit",
+			wakeups: []int{0, 300, 600, 900, 1200, 1500, 1800, 2100, 2400, 2700},
+		},
+		{
+			// a key that stays hot is reported again once the window after the report elapsed
+			name:     "sustained",
+			wakeups:  []int{0, 100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100, 1200, 1300, 1400, 1500, 1600},
+			reported: []int{500, 1500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hotKey{limit: 5, window: time.Second}
+			var reported []int
+			for _, ms := range tt.wakeups {
+				if err := h.observe(at(ms), "key"); err != nil {
+					if !errors.Is(err, ErrHotKey) {
+						t.Fatalf("got %v, want ErrHotKey", err)
+					}
+					reported = append(reported, ms)
+				}
+			}
+			if len(reported) != len(tt.reported) {
+				t.Fatalf("got reports at %v ms, want %v", reported, tt.reported)
+			}
+			for i := range reported {
+				if reported[i] != tt.reported[i] {
+					t.Fatalf("got reports at %v ms, want %v", reported, tt.reported)
+				}
+			}
+		})
+	}
+}
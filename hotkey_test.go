@@ -0,0 +1,62 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestHotKeyThreshold(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes int
+		want   int
+	}{
+		{name: "hot", writes: 10, want: 1},
+		{name: "calm", writes: 3, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("lock", []byte("0"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			errs := make(chan error, 20)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "lock", watcher.WithHotKeyThreshold(5, time.Minute),
+				watcher.WithErrorHandler(func(err error) {
+					errs <- err
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+
+			for i := 1; i <= tt.writes; i++ {
+				kv.Put("lock", []byte(fmt.Sprint(i)))
+				<-pairs
+			}
+
+			// the hot key is reported once per window
+			got := 0
+			for len(errs) > 0 {
+				if err := <-errs; !errors.Is(err, watcher.ErrHotKey) || !strings.Contains(err.Error(), "lock") {
+					t.Fatalf("got %v, want ErrHotKey naming the key", err)
+				}
+				got++
+			}
+			if got != tt.want {
+				t.Fatalf("got %d reports, want %d", got, tt.want)
+			}
+		})
+	}
+}
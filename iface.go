@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"context"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// KVWatcher are the basic watch methods of Watcher. Consumers can depend on it instead of
+// *Watcher to replace it in tests, see package watchertest for an in-memory implementation.
+type KVWatcher interface {
+	WatchKey(ctx context.Context, key string, opts ...WatchOption) (<-chan *consul.KVPair, error)
+	WatchTree(ctx context.Context, path string, opts ...WatchOption) (<-chan consul.KVPairs, error)
+}
+
+var _ KVWatcher = (*Watcher)(nil)
+
+// KVClient are the KV store methods used by the watches, it is implemented by the KV client of *consul.Client
+// and replaced with WithKVClient, e.g. by the in-memory watchertest.KV
+type KVClient interface {
+	Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error)
+	List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error)
+	Keys(prefix, separator string, q *consul.QueryOptions) ([]string, *consul.QueryMeta, error)
+	Txn(txn consul.KVTxnOps, q *consul.QueryOptions) (bool, *consul.KVTxnResponse, *consul.QueryMeta, error)
+}
+
+// CatalogClient is the catalog method used to enumerate datacenters, it is implemented by the
+// catalog client of *consul.Client and replaced with WithCatalogClient
+type CatalogClient interface {
+	Datacenters() ([]string, error)
+}
+
+// StatusClient is the status method used by Ping, it is implemented by the status client of
+// *consul.Client and replaced with WithStatusClient
+type StatusClient interface {
+	LeaderWithQueryOptions(q *consul.QueryOptions) (string, error)
+}
+
+var (
+	_ KVClient      = (*consul.KV)(nil)
+	_ CatalogClient = (*consul.Catalog)(nil)
+	_ StatusClient  = (*consul.Status)(nil)
+)
+
+// WithKVClient replaces the KV client of the Consul client for all watches
+func WithKVClient(kv KVClient) Option {
+	return func(w *Watcher) {
+		w.kv = kv
+	}
+}
+
+// WithCatalogClient replaces the catalog client of the Consul client used by WatchKeyAllDatacenters
+func WithCatalogClient(catalog CatalogClient) Option {
+	return func(w *Watcher) {
+		w.catalog = catalog
+	}
+}
+
+// WithStatusClient replaces the status client of the Consul client used by Ping
+func WithStatusClient(status StatusClient) Option {
+	return func(w *Watcher) {
+		w.status = status
+	}
+}
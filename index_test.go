@@ -0,0 +1,160 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// zeroIndexKV answers queries waiting on index 0 with index 0 and blocks all others like Consul does
+type zeroIndexKV struct {
+	*watchertest.KV
+	queries chan uint64
+}
+
+func (kv *zeroIndexKV) answer(q *consul.QueryOptions) (*consul.QueryMeta, error) {
+	kv.queries <- q.WaitIndex
+	if q.WaitIndex > 0 {
+		<-q.Context().Done()
+		return nil, q.Context().Err()
+	}
+
+	return &consul.QueryMeta{LastIndex: 0, KnownLeader: true}, nil
+}
+
+func (kv *zeroIndexKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	meta, err := kv.answer(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &consul.KVPair{Key: key, Value: []byte("value")}, meta, nil
+}
+
+func (kv *zeroIndexKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	meta, err := kv.answer(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return consul.KVPairs{{Key: prefix + "a", Value: []byte("value")}}, meta, nil
+}
+
+func TestZeroIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch func(ctx context.Context, w *watcher.Watcher) error
+	}{
+		{
+			name: "key",
+			watch: func(ctx context.Context, w *watcher.Watcher) error {
+				_, err := w.WatchKey(ctx, "key")
+				return err
+			},
+		},
+		{
+			name: "tree",
+			watch: func(ctx context.Context, w *watcher.Watcher) error {
+				_, err := w.WatchTree(ctx, "app/")
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := &zeroIndexKV{KV: watchertest.NewKV(), queries: make(chan uint64, 10)}
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := tt.watch(ctx, w); err != nil {
+				t.Fatal(err)
+			}
+
+			// the query after a zero index waits on index 1 instead of returning at once again
+			if index := <-kv.queries; index != 0 {
+				t.Fatalf("got wait index %d for the first query, want 0", index)
+			}
+			if index := <-kv.queries; index != 1 {
+				t.Fatalf("got wait index %d after a zero index, want 1", index)
+			}
+			select {
+			case index := <-kv.queries:
+				t.Fatalf("got another query with wait index %d, want the watch blocking", index)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// flakyKV fails the first blocking query with a timeout and records the wait index of all later queries
+type flakyKV struct {
+	*watchertest.KV
+	failed  int32
+	indexes chan uint64
+}
+
+func (kv *flakyKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if q.WaitIndex > 0 && atomic.CompareAndSwapInt32(&kv.failed, 0, 1) {
+		return nil, nil, timeoutError{}
+	}
+	if atomic.LoadInt32(&kv.failed) == 1 {
+		kv.indexes <- q.WaitIndex
+	}
+	return kv.KV.Get(key, q)
+}
+
+func TestResetIndexOnError(t *testing.T) {
+	tests := []struct {
+		name      string
+		reset     func(err error) bool
+		preserved bool
+	}{
+		{name: "default", preserved: false},
+		{
+			name: "disabled for timeouts",
+			reset: func(err error) bool {
+				var netErr net.Error
+				return !errors.As(err, &netErr) || !netErr.Timeout()
+			},
+			preserved: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := &flakyKV{KV: watchertest.NewKV(), indexes: make(chan uint64, 10)}
+			kv.Put("key", []byte("value"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			var opts []watcher.WatchOption
+			if tt.reset != nil {
+				opts = append(opts, watcher.WithResetIndexOnError(tt.reset))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+
+			if index := <-kv.indexes; (index > 0) != tt.preserved {
+				t.Fatalf("got wait index %d for the retry, want it preserved %v", index, tt.preserved)
+			}
+			select {
+			case pair := <-pairs:
+				t.Fatalf("got emission %s of the unchanged value after the timeout", pair.Value)
+			case <-time.After(30 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchKeyIndirect watches a key whose value names another key and emits the key value pair of that target.
+// Whenever the pointer changes, the watch switches to the new target and emits its current value. The target
+// is resolved like any watched key, including the key prefix of the Watcher, surrounding whitespace is ignored.
+// A dangling pointer, i.e. a missing or empty pointer key or a missing target, emits nil. Errors starting the
+// watch of a target are passed to the error handler and emit nil as well. The channel is closed when the watch
+// of the pointer key ends.
+func (w *Watcher) WatchKeyIndirect(
+	ctx context.Context, key string, opts ...WatchOption,
+) (<-chan *consul.KVPair, error) {
+	pointers, err := w.WatchKey(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	o := w.newWatchOptions(opts)
+	out := make(chan *consul.KVPair)
+	go func() {
+		defer close(out)
+
+		var target string
+		var targets <-chan *consul.KVPair
+		cancelTarget := func() {}
+		// stopTarget ends the watch of the current target, its channel is read until it is closed,
+		// so an emitter draining a pending value with WithCloseDrain can't block forever
+		stopTarget := func() {
+			cancelTarget()
+			if targets != nil {
+				go discard(targets)
+			}
+			target, targets = "", nil
+		}
+		defer func() {
+			stopTarget()
+		}()
+
+		watchTarget := func(target string) (<-chan *consul.KVPair, context.CancelFunc, error) {
+			targetCtx, cancel := context.WithCancel(ctx)
+			pairs, err := w.WatchKey(targetCtx, target, opts...)
+			if err != nil {
+				cancel()
+				return nil, func() {}, err
+			}
+			return pairs, cancel, nil
+		}
+
+		send := func(pair *consul.KVPair) {
+			select {
+			case out <- pair:
+			case <-ctx.Done():
+			}
+		}
+
+		for {
+			select {
+			case pointer, ok := <-pointers:
+				if !ok {
+					return
+				}
+
+				next := ""
+				if pointer != nil {
+					next = strings.TrimSpace(string(pointer.Value))
+				}
+				if next == target && targets != nil {
+					continue
+				}
+
+				stopTarget()
+				target = next
+				if target == "" {
+					send(nil)
+					continue
+				}
+
+				var err error
+				targets, cancelTarget, err = watchTarget(target)
+				if err != nil {
+					o.handleError(err)
+					send(nil)
+				}
+			case pair, ok := <-targets:
+				if !ok {
+					// the target watch ended, wait for the pointer to change
+					targets = nil
+					continue
+				}
+				send(pair)
+			}
+		}
+	}()
+
+	return out, nil
+}
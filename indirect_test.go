@@ -0,0 +1,48 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchKeyIndirect(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("active", []byte("config/blue\n"))
+	kv.Put("config/blue", []byte("blue"))
+	kv.Put("config/green", []byte("green"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyIndirect(ctx, "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; pair == nil || string(pair.Value) != "blue" {
+		t.Fatalf("got %v, want blue", pair)
+	}
+
+	// repointing switches to the current value of the new target
+	kv.Put("active", []byte("config/green"))
+	if pair := <-pairs; pair == nil || pair.Key != "config/green" || string(pair.Value) != "green" {
+		t.Fatalf("got %v, want green", pair)
+	}
+
+	// the previous target isn't watched anymore
+	kv.Put("config/blue", []byte("blue2"))
+	kv.Put("config/green", []byte("green2"))
+	if pair := <-pairs; pair == nil || string(pair.Value) != "green2" {
+		t.Fatalf("got %v, want green2", pair)
+	}
+
+	// a dangling pointer emits nil
+	kv.Put("active", []byte("config/red"))
+	if pair := <-pairs; pair != nil {
+		t.Fatalf("got %v, want nil for a dangling pointer", pair)
+	}
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ConnectClient is the Connect method used by WatchIntentions, it is implemented by the Connect client
+// of *consul.Client and replaced with WithConnectClient
+type ConnectClient interface {
+	Intentions(q *consul.QueryOptions) ([]*consul.Intention, *consul.QueryMeta, error)
+}
+
+var _ ConnectClient = (*consul.Connect)(nil)
+
+// WithConnectClient replaces the Connect client of the Consul client used by WatchIntentions
+func WithConnectClient(connect ConnectClient) Option {
+	return func(w *Watcher) {
+		w.connect = connect
+	}
+}
+
+// WatchIntentions watches the Connect intentions and emits the full list whenever an intention was added,
+// changed or removed. It uses the same query loop, debounce and retries as the KV watches.
+func (w *Watcher) WatchIntentions(ctx context.Context, opts ...WatchOption) (<-chan []*consul.Intention, error) {
+	o := w.newWatchOptions(opts)
+	return startWatch(ctx, w, o, w.intentionsSource(""), valueOnly[[]*consul.Intention])
+}
+
+// WatchServiceIntentions works like WatchIntentions but only emits the intentions that have service as source
+// or destination. Changes to intentions of other services don't emit.
+func (w *Watcher) WatchServiceIntentions(
+	ctx context.Context, service string, opts ...WatchOption,
+) (<-chan []*consul.Intention, error) {
+	o := w.newWatchOptions(opts)
+	return startWatch(ctx, w, o, w.intentionsSource(service), valueOnly[[]*consul.Intention])
+}
+
+// intentionsSource returns the source for watching the intentions, filtered by service if it is not empty
+func (w *Watcher) intentionsSource(service string) source[[]*consul.Intention] {
+	connect := w.connect
+	src := source[[]*consul.Intention]{
+		kind:   KindIntentions,
+		target: service,
+		fetch: func(opts *consul.QueryOptions) ([]*consul.Intention, *consul.QueryMeta, error) {
+			intentions, meta, err := connect.Intentions(opts)
+			if err != nil || service == "" {
+				return intentions, meta, err
+			}
+
+			filtered := make([]*consul.Intention, 0, len(intentions))
+			for _, intention := range intentions {
+				if intention.SourceName == service || intention.DestinationName == service {
+					filtered = append(filtered, intention)
+				}
+			}
+			return filtered, meta, nil
+		},
+		identity: intentionsHash,
+	}
+	if connect == nil {
+		src.err = missingClient("Connect")
+	}
+
+	return src
+}
+
+// intentionsHash returns a hash over the IDs and modify indexes of intentions independent of their order
+func intentionsHash(intentions []*consul.Intention) string {
+	sorted := make([]*consul.Intention, 0, len(intentions))
+	for _, intention := range intentions {
+		if intention != nil {
+			sorted = append(sorted, intention)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	h := sha256.New()
+	var buf [8]byte
+	for _, intention := range sorted {
+		binary.BigEndian.PutUint64(buf[:], uint64(len(intention.ID)))
+		h.Write(buf[:])
+		h.Write([]byte(intention.ID))
+		binary.BigEndian.PutUint64(buf[:], intention.ModifyIndex)
+		h.Write(buf[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
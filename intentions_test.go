@@ -0,0 +1,116 @@
+package watcher_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+)
+
+// connectClient is a ConnectClient with blocking queries on a list of intentions
+type connectClient struct {
+	mu         sync.Mutex
+	index      uint64
+	intentions []*consul.Intention
+	changed    chan struct{}
+}
+
+func newConnectClient() *connectClient {
+	return &connectClient{index: 1, changed: make(chan struct{})}
+}
+
+func (c *connectClient) set(intentions ...*consul.Intention) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.index++
+	c.intentions = intentions
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *connectClient) Intentions(q *consul.QueryOptions) ([]*consul.Intention, *consul.QueryMeta, error) {
+	c.mu.Lock()
+	for c.index <= q.WaitIndex {
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+
+	return append([]*consul.Intention(nil), c.intentions...), &consul.QueryMeta{LastIndex: c.index, KnownLeader: true}, nil
+}
+
+// intention returns an intention from source to destination
+func intention(source, destination string) *consul.Intention {
+	return &consul.Intention{
+		ID:              source + "-" + destination,
+		SourceName:      source,
+		DestinationName: destination,
+		Action:          consul.IntentionActionAllow,
+	}
+}
+
+func TestWatchIntentions(t *testing.T) {
+	checkGoroutines(t)
+	connect := newConnectClient()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithConnectClient(connect))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists, err := w.WatchIntentions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := <-lists; len(list) != 0 {
+		t.Fatalf("got %d intentions, want none", len(list))
+	}
+
+	connect.set(intention("web", "db"))
+	if list := <-lists; len(list) != 1 || list[0].ID != "web-db" {
+		t.Fatalf("got %v, want the added web-db", list)
+	}
+
+	connect.set()
+	if list := <-lists; len(list) != 0 {
+		t.Fatalf("got %d intentions, want none after the removal", len(list))
+	}
+}
+
+func TestWatchServiceIntentions(t *testing.T) {
+	checkGoroutines(t)
+	connect := newConnectClient()
+	connect.set(intention("web", "db"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithConnectClient(connect))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists, err := w.WatchServiceIntentions(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := <-lists; len(list) != 1 {
+		t.Fatalf("got %d intentions, want web-db", len(list))
+	}
+
+	// an intention between other services doesn't emit
+	connect.set(intention("web", "db"), intention("web", "cache"))
+	select {
+	case list := <-lists:
+		t.Fatalf("got %d intentions for a change of other services", len(list))
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	connect.set(intention("web", "db"), intention("web", "cache"), intention("api", "db"))
+	if list := <-lists; len(list) != 2 {
+		t.Fatalf("got %d intentions, want web-db and api-db", len(list))
+	}
+}
@@ -0,0 +1,69 @@
+//go:build go1.23
+
+package watcher
+
+import (
+	"context"
+	"iter"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// KeyUpdates returns an iterator over the changes of a key, an alternative to WatchKey for range-over-func:
+//
+//	for pair, err := range w.KeyUpdates(ctx, "config/app") {
+//		if err != nil {
+//			return err
+//		}
+//		reload(pair)
+//	}
+//
+// The iteration stops when ctx is done or after yielding the terminal error of the watch, which includes
+// context.DeadlineExceeded. Breaking out of the loop stops the watch.
+func (w *Watcher) KeyUpdates(ctx context.Context, key string, opts ...WatchOption) iter.Seq2[*consul.KVPair, error] {
+	return func(yield func(*consul.KVPair, error) bool) {
+		o := w.newWatchOptions(opts)
+		iterate(ctx, w, o, w.keySource(key, o), yield)
+	}
+}
+
+// TreeUpdates returns an iterator over the changes of a directory, an alternative to WatchTree for
+// range-over-func. It stops like KeyUpdates.
+func (w *Watcher) TreeUpdates(ctx context.Context, path string, opts ...WatchOption) iter.Seq2[consul.KVPairs, error] {
+	return func(yield func(consul.KVPairs, error) bool) {
+		o := w.newWatchOptions(opts)
+		iterate(ctx, w, o, w.treeSource(path, o), yield)
+	}
+}
+
+// iterate runs a watch for src and yields its emissions until yield returns false or the watch ends
+func iterate[T any](ctx context.Context, w *Watcher, o *watchOptions, src source[T], yield func(T, error) bool) {
+	var zero T
+	if err := checkContext(ctx); err != nil {
+		yield(zero, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, err := start(ctx, w, o, src, stateless(valueOnly[T]))
+	if err != nil {
+		yield(zero, err)
+		return
+	}
+
+	for value := range r.out {
+		if !yield(value, nil) {
+			// discard a value flushed by WithCloseDrain so the watch can end
+			cancel()
+			for range r.out {
+			}
+			return
+		}
+	}
+
+	if err := r.err(); err != nil {
+		yield(zero, err)
+	}
+}
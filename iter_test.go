@@ -0,0 +1,37 @@
+//go:build go1.23
+
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func ExampleWatcher_KeyUpdates() {
+	kv := watchertest.NewKV()
+	kv.Put("config/app", []byte("v1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for pair, err := range w.KeyUpdates(ctx, "config/app") {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(pair.Value))
+		if string(pair.Value) == "v2" {
+			// breaking out of the loop stops the watch
+			break
+		}
+
+		kv.Put("config/app", []byte("v2"))
+	}
+	// Output:
+	// v1
+	// v2
+}
@@ -0,0 +1,49 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// WatchKeyInto watches for changes to a key and emits its JSON decoded value. Every value is decoded
+// into a fresh target returned by newTarget, which must return a pointer. Decode errors are sent to the
+// error channel without ending the watch. Deleted or missing keys are not emitted.
+// Both channels must be drained, they are closed when the watch ends.
+func (w *Watcher) WatchKeyInto(
+	ctx context.Context, key string, newTarget func() interface{}, opts ...WatchOption,
+) (<-chan interface{}, <-chan error, error) {
+	pairs, err := w.WatchKey(ctx, key, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan interface{})
+	errs := make(chan error)
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		for pair := range pairs {
+			if pair == nil {
+				continue
+			}
+
+			target := newTarget()
+			if err := json.Unmarshal(pair.Value, target); err != nil {
+				select {
+				case errs <- fmt.Errorf("decode %s: %w", pair.Key, err):
+				case <-ctx.Done():
+				}
+				continue
+			}
+
+			select {
+			case out <- target:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, errs, nil
+}
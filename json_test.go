@@ -0,0 +1,50 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+type jsonConfig struct {
+	Name string `json:"name"`
+}
+
+func TestWatchKeyInto(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("config", []byte(`{"name":"a"}`))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs, err := w.WatchKeyInto(ctx, "config", func() interface{} { return &jsonConfig{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		for range values {
+		}
+		for range errs {
+		}
+	}()
+
+	if value := (<-values).(*jsonConfig); value.Name != "a" {
+		t.Fatalf("got %q, want a", value.Name)
+	}
+
+	// a malformed payload is reported without ending the watch
+	kv.Put("config", []byte(`{"name":`))
+	if err := <-errs; err == nil {
+		t.Fatal("got nil, want a decode error")
+	}
+
+	kv.Put("config", []byte(`{"name":"b"}`))
+	if value := (<-values).(*jsonConfig); value.Name != "b" {
+		t.Fatalf("got %q, want b", value.Name)
+	}
+}
@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// KeyTreeSnapshot is the state of a key together with the keys below it
+type KeyTreeSnapshot struct {
+	// Key is the key value pair of the key itself, nil if only children exist
+	Key *consul.KVPair
+	// Children are all key value pairs below the key
+	Children consul.KVPairs
+}
+
+// WatchKeyAndTree watches a key and all keys below it with a single blocking query, so the emitted
+// key and children always belong to the same state. Keys that only share the prefix without
+// a separating slash, like "config/apple" for "config/app", are ignored.
+func (w *Watcher) WatchKeyAndTree(
+	ctx context.Context, key string, opts ...WatchOption,
+) (<-chan KeyTreeSnapshot, error) {
+	o := w.newWatchOptions(opts)
+	key = strings.TrimSuffix(key, "/")
+	src := w.treeSource(key, o)
+
+	full := w.emittedKey(key)
+	fetch := src.fetch
+	src.fetch = func(opts *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+		pairs, meta, err := fetch(opts)
+		if err != nil {
+			return nil, meta, err
+		}
+
+		// keep the key and its children only
+		filtered := make(consul.KVPairs, 0, len(pairs))
+		for _, pair := range pairs {
+			if pair.Key == full || strings.HasPrefix(pair.Key, full+"/") {
+				filtered = append(filtered, pair)
+			}
+		}
+		return filtered, meta, nil
+	}
+
+	return startWatch(ctx, w, o, src, func(pairs consul.KVPairs, _ Meta) KeyTreeSnapshot {
+		var snapshot KeyTreeSnapshot
+		for _, pair := range pairs {
+			if pair.Key == full {
+				snapshot.Key = pair
+			} else {
+				snapshot.Children = append(snapshot.Children, pair)
+			}
+		}
+		return snapshot
+	})
+}
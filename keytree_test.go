@@ -0,0 +1,106 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchKeyAndTree(t *testing.T) {
+	type step struct {
+		// write changes the KV before the snapshot is received, nil for the first snapshot
+		write        func(kv *watchertest.KV)
+		wantKey      string
+		wantChildren []string
+	}
+	tests := []struct {
+		name  string
+		setup map[string]string
+		steps []step
+	}{
+		{
+			name:  "key missing while children exist",
+			setup: map[string]string{"config/app/a": "1", "config/app/b": "1", "config/apple": "1"},
+			steps: []step{
+				{wantChildren: []string{"config/app/a", "config/app/b"}},
+				{
+					write:        func(kv *watchertest.KV) { kv.Put("config/app", []byte("key")) },
+					wantKey:      "key",
+					wantChildren: []string{"config/app/a", "config/app/b"},
+				},
+			},
+		},
+		{
+			name:  "key without children",
+			setup: map[string]string{"config/app": "key", "config/apple": "1"},
+			steps: []step{
+				{wantKey: "key"},
+				{
+					write:        func(kv *watchertest.KV) { kv.Put("config/app/a", []byte("1")) },
+					wantKey:      "key",
+					wantChildren: []string{"config/app/a"},
+				},
+			},
+		},
+		{
+			name:  "key and children deleted",
+			setup: map[string]string{"config/app": "key", "config/app/a": "1"},
+			steps: []step{
+				{wantKey: "key", wantChildren: []string{"config/app/a"}},
+				{
+					write:        func(kv *watchertest.KV) { kv.Delete("config/app") },
+					wantChildren: []string{"config/app/a"},
+				},
+				{write: func(kv *watchertest.KV) { kv.Delete("config/app/a") }},
+			},
+		},
+		{
+			name:  "nothing exists",
+			setup: map[string]string{"config/apple": "1"},
+			steps: []step{
+				{},
+				{
+					write:   func(kv *watchertest.KV) { kv.Put("config/app", []byte("key")) },
+					wantKey: "key",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			for key, value := range tt.setup {
+				kv.Put(key, []byte(value))
+			}
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			snapshots, err := w.WatchKeyAndTree(ctx, "config/app/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				if s.write != nil {
+					s.write(kv)
+				}
+				snapshot := <-snapshots
+				switch {
+				case s.wantKey == "" && snapshot.Key != nil:
+					t.Fatalf("step %d: got key %s, want none", i, snapshot.Key.Value)
+				case s.wantKey != "" && (snapshot.Key == nil || string(snapshot.Key.Value) != s.wantKey):
+					t.Fatalf("step %d: got key %v, want %s", i, snapshot.Key, s.wantKey)
+				}
+				if keys := treeKeys(snapshot.Children); !equalStrings(keys, s.wantChildren) {
+					t.Fatalf("step %d: got children %v, want %v", i, keys, s.wantChildren)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// drain reads ch until it is closed on its own goroutine
+func drain[T any](ch <-chan T) {
+	go func() {
+		for range ch {
+		}
+	}()
+}
+
+// startAll starts a watch of every kind on w, the watches end when ctx is done
+func startAll(t *testing.T, ctx context.Context, w *watcher.Watcher, i int) {
+	t.Helper()
+	key := fmt.Sprintf("app/%d", i%4)
+	opts := []watcher.WatchOption{
+		watcher.WithDebounceOverride(time.Hour),
+		watcher.WithDeleteGrace(time.Hour),
+	}
+
+	watch := func(start func() error) {
+		t.Helper()
+		if err := start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watch(func() error { ch, err := w.WatchKey(ctx, key, opts...); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchTree(ctx, "app/", opts...); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchAuto(ctx, "app", opts...); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchTreeChanges(ctx, "app/", opts...); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchTreePatch(ctx, "app/", opts...); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchKeyIndirect(ctx, "pointer"); drain(ch); return err })
+	watch(func() error { ch, err := w.WatchKeyAllDatacenters(ctx, key, opts...); drain(ch); return err })
+	watch(func() error {
+		ch, err := w.WatchMergedTrees(ctx, []string{"app/", "other/"}, opts...)
+		drain(ch)
+		return err
+	})
+	watch(func() error {
+		streams, err := w.WatchTreePerKey(ctx, "app/")
+		go func() {
+			for stream := range streams {
+				drain(stream.Updates)
+			}
+		}()
+		return err
+	})
+	watch(func() error {
+		values, errs, err := w.WatchKeyTransform(ctx, key, func(pair *consul.KVPair) (interface{}, error) {
+			return pair, nil
+		})
+		drain(values)
+		drain(errs)
+		return err
+	})
+	watch(func() error {
+		return w.WatchTreeApply(ctx, "app/", func(_, _, _ consul.KVPairs) error {
+			return nil
+		})
+	})
+	watch(func() error {
+		ch, err := w.WatchKey(ctx, key, watcher.WithBackpressure(watcher.DropOldest, 4), watcher.WithCloseDrain())
+		drain(ch)
+		return err
+	})
+}
+
+func TestNoLeakedGoroutines(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("pointer", []byte("app/0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0,
+		watcher.WithKVClient(kv),
+		watcher.WithCatalogClient(watchertest.NewCatalog("dc1", "dc2")),
+		watcher.WithDeliveryWorkers(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 20; i++ {
+		startAll(t, ctx, w, i)
+	}
+	// debounce timers and delete grace timers are pending while the watches are cancelled
+	for i := 0; i < 4; i++ {
+		kv.Put(fmt.Sprintf("app/%d", i), []byte("value"))
+	}
+	kv.Delete("app/0")
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+}
+
+func TestNoLeakedGoroutinesWatcherShutdown(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := watcher.NewWithContext(ctx, nil, 10*time.Millisecond, 0,
+		watcher.WithKVClient(kv), watcher.WithCatalogClient(watchertest.NewCatalog("dc1")))
+
+	for i := 0; i < 10; i++ {
+		startAll(t, context.Background(), w, i)
+	}
+	kv.Put("app/0", []byte("value"))
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+}
+
+func TestNoLeakedDebounceTimers(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 50*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var keys []<-chan *consul.KVPair
+	var trees []<-chan consul.KVPairs
+	for i := 0; i < 20; i++ {
+		pairs, err := w.WatchKey(ctx, fmt.Sprintf("app/%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := w.WatchTree(ctx, "app/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-pairs
+		<-tree
+		keys = append(keys, pairs)
+		trees = append(trees, tree)
+	}
+
+	// every watch has a pending debounce timer when it is cancelled
+	for i := 0; i < 20; i++ {
+		kv.Put(fmt.Sprintf("app/%d", i), []byte("value"))
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// the channels close without the debounced value, also after the debounce time elapsed
+	time.Sleep(50 * time.Millisecond)
+	for i := range keys {
+		if pair, ok := <-keys[i]; ok {
+			t.Fatalf("got %v after cancel, want the pending value dropped", pair)
+		}
+		if tree, ok := <-trees[i]; ok {
+			t.Fatalf("got %v after cancel, want the pending value dropped", treeKeys(tree))
+		}
+	}
+}
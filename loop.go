@@ -0,0 +1,689 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// source describes how a watch reads its value from Consul
+type source[T any] struct {
+	kind   WatchKind
+	target string
+	fetch  func(opts *consul.QueryOptions) (T, *consul.QueryMeta, error)
+	// identity is optional, a new index whose value has the same identity as the previous one is not emitted
+	identity func(T) string
+	// exists is optional and reports whether the value exists in Consul
+	exists func(T) bool
+	// copy is optional and returns a deep copy of an emitted value
+	copy func(T) T
+	// fallback is optional and returns the value for the contents of a fallback file
+	fallback func(data []byte) T
+	// validate is optional and returns an error for a value that must not be emitted
+	validate func(T) error
+	// hash is optional and returns a content hash of a value, for comparing values of sources without identity
+	hash func(T) string
+	// isDefault is optional and reports whether the value is a default for a missing value
+	isDefault func(T) bool
+	// err is set if the source can't be read at all, e.g. because the client it depends on is missing
+	err error
+}
+
+// valueOnly is the wrap func for watches that emit the plain value
+func valueOnly[T any](value T, _ Meta) T {
+	return value
+}
+
+// statefulWrap converts a value and its Meta into an emission like a wrap func and also returns commit,
+// which is called once the emission was delivered. Wraps that depend on the previous emission only advance
+// their state in commit, so values that were dropped or replaced by the backpressure policy don't count.
+// A nil commit is allowed. Both are only called on the emitter goroutine.
+type statefulWrap[T, E any] func(T, Meta) (emission E, commit func())
+
+// stateless adapts a wrap func without state to a statefulWrap
+func stateless[T, E any](wrap func(T, Meta) E) statefulWrap[T, E] {
+	return func(value T, meta Meta) (E, func()) {
+		return wrap(value, meta), nil
+	}
+}
+
+// run is a single running watch. The query loop in poll and the debouncing in emit
+// run on separate goroutines and only communicate through the changes channel.
+type run[T, E any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	w      *Watcher
+	o      *watchOptions
+	src    source[T]
+	state  *watchState
+	out    chan E
+	// done is closed after the watch ended completely
+	done chan struct{}
+	// wrap converts every emission before it is sent to out
+	wrap statefulWrap[T, E]
+	// consistentNext is set to 1 to make the next query consistent, it is accessed atomically
+	consistentNext int32
+	// reconciler is the state of the consistent reconcile, nil if it is disabled
+	reconciler *reconciler[T]
+	// resetBackoff interrupts a backoff sleep of poll, it is buffered so a reset while a query runs isn't lost
+	resetBackoff chan struct{}
+}
+
+// startWatch starts the query loop for src and returns the channel its emissions are sent to.
+// Every emission is converted with wrap before it is sent.
+func startWatch[T, E any](
+	ctx context.Context, w *Watcher, o *watchOptions, src source[T], wrap func(T, Meta) E,
+) (<-chan E, error) {
+	return startStatefulWatch(ctx, w, o, src, stateless(wrap))
+}
+
+// startStatefulWatch works like startWatch for a wrap that depends on the previously delivered emission
+func startStatefulWatch[T, E any](
+	ctx context.Context, w *Watcher, o *watchOptions, src source[T], wrap statefulWrap[T, E],
+) (<-chan E, error) {
+	r, err := start(ctx, w, o, src, wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.out, nil
+}
+
+// start starts the query loop for src and returns the running watch
+func start[T, E any](
+	ctx context.Context, w *Watcher, o *watchOptions, src source[T], wrap statefulWrap[T, E],
+) (*run[T, E], error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if src.err != nil {
+		return nil, src.err
+	}
+	if o.probeOnStart {
+		if err := probe(ctx, o, src); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := w.watchContext(ctx)
+	r := &run[T, E]{
+		ctx:    ctx,
+		cancel: cancel,
+		w:      w,
+		o:      o,
+		src:    src,
+		state:  w.register(src.kind, src.target, o.datacenter),
+		out:    make(chan E),
+		done:   make(chan struct{}),
+		wrap:   wrap,
+
+		resetBackoff: make(chan struct{}, 1),
+		reconciler:   newReconciler(o, src),
+	}
+
+	changes := make(chan change[T])
+	go r.poll(changes)
+	go r.emit(r.debounce(changes))
+
+	return r, nil
+}
+
+// probe runs a single non-blocking query for src and returns its error if it is not retryable
+func probe[T any](ctx context.Context, o *watchOptions, src source[T]) error {
+	opts := &consul.QueryOptions{
+		AllowStale: true,
+		Datacenter: o.datacenter,
+	}
+	_, _, err := src.fetch(opts.WithContext(ctx))
+	if err != nil && !isRetryable(err) {
+		return classifyError(err)
+	}
+
+	return nil
+}
+
+// err returns the terminal error of the watch after out was closed, this is either the error
+// that ended the watch or context.DeadlineExceeded, nil if the watch was cancelled
+func (r *run[T, E]) err() error {
+	if err := r.state.info().Err; err != nil {
+		return err
+	}
+	if errors.Is(r.ctx.Err(), context.DeadlineExceeded) {
+		return r.ctx.Err()
+	}
+
+	return nil
+}
+
+// poll runs the blocking query loop and sends every change to changes until ctx is done
+// or a non-retryable error occurs. It closes changes before returning.
+// If the watch ends because the deadline of ctx expired, context.DeadlineExceeded is passed
+// to the error handler, a plain cancellation is not reported.
+func (r *run[T, E]) poll(changes chan<- change[T]) {
+	defer close(changes)
+
+	ctx, w, o := r.ctx, r.w, r.o
+	if r.reconciler != nil {
+		// the reconcile reads end with the query loop, so no query outlives it
+		reconcileCtx, stopReconcile := context.WithCancel(ctx)
+		reconciled := make(chan struct{})
+		go func() {
+			defer close(reconciled)
+			r.reconcile(reconcileCtx)
+		}()
+		defer func() {
+			stopReconcile()
+			<-reconciled
+		}()
+	}
+	defer func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			o.handleError(ctx.Err())
+		}
+	}()
+	opts := &consul.QueryOptions{
+		AllowStale:        true,
+		RequireConsistent: false,
+		UseCache:          true,
+		WaitTime:          o.waitTime,
+		Datacenter:        o.datacenter,
+		MaxAge:            o.maxAge,
+		StaleIfError:      o.staleIfError,
+		WaitIndex:         o.startIndex,
+	}
+
+	bf := w.newBackOff()
+	resync := newResync(o.resyncInterval, o.waitTime)
+	var timeouts transportTimeouts
+	hot := hotKey{limit: o.hotKeyLimit, window: o.hotKeyWindow}
+	var lastIdentity string
+	// forwarded is set once a value was passed on for emission
+	forwarded := false
+	// existed tracks whether the value existed once, for waiting on the existence
+	existed := !o.waitForExistence || r.src.exists == nil
+	// attempt counts the consecutive failed queries that are retried since failingSince
+	attempt := 0
+	var failingSince time.Time
+	// fallbackTried is set once the fallback file was read
+	fallbackTried := false
+	// knownLeader is the KnownLeader of the last query result
+	knownLeader := true
+	// lastQueryStart is when the previous query was sent, for the minimum poll interval
+	var lastQueryStart time.Time
+	// escapingStale is set while a query is re-issued after a stale follower was detected
+	escapingStale := false
+	// releaseProbe releases a half-open probe of the circuit breaker whose result wasn't recorded
+	releaseProbe := func() {}
+	defer func() {
+		releaseProbe()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		release, err := w.breaker.wait(ctx)
+		if err != nil {
+			return
+		}
+		releaseProbe = release
+
+		resync.prepare(opts)
+		if d := o.pollSpacing(opts, lastQueryStart); d > 0 && !sleep(ctx, d) {
+			return
+		}
+		if err := w.requests.acquire(ctx); err != nil {
+			return
+		}
+		queryCtx, endQuery, correction := r.reconciler.queryContext(ctx)
+		if correction != nil {
+			o.handleError(correction)
+			opts.WaitIndex = 0
+			opts.WaitHash = ""
+		}
+		consistent := atomic.CompareAndSwapInt32(&r.consistentNext, 1, 0)
+		if consistent {
+			opts.AllowStale = false
+			opts.RequireConsistent = true
+			opts.UseCache = false
+		}
+		queryStart := time.Now()
+		lastQueryStart = queryStart
+		value, meta, err := r.src.fetch(o.mutateQuery(opts).WithContext(queryCtx))
+		latency := time.Since(queryStart)
+		interrupted := ctx.Err() == nil && queryCtx.Err() != nil
+		endQuery()
+		w.requests.release()
+		if consistent && !escapingStale {
+			restoreStale(opts)
+		}
+		if err != nil && interrupted {
+			// the reconciler found a newer value, the next query realigns the watch
+			releaseProbe()
+			continue
+		}
+		if ctx.Err() == nil {
+			if diag := timeouts.observe(opts, latency, err); diag != nil {
+				o.handleError(diag)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			o.queryLatency(latency, false)
+			reported := classifyError(err)
+			retryable := isRetryable(err) && !o.failFast
+			if retryable || o.neverGiveUp {
+				attempt++
+				if failingSince.IsZero() {
+					failingSince = queryStart
+				}
+			}
+
+			if retryable {
+				w.breaker.failure()
+				delay, ok := retryAfter(err)
+				if !ok {
+					delay = bf.NextBackOff()
+				}
+				o.handleError(&RetryError{Err: reported, Attempt: attempt, Elapsed: time.Since(failingSince), NextBackoff: delay})
+				if !forwarded && !fallbackTried {
+					fallbackTried = true
+					if c, ok := r.fallbackChange(); ok {
+						select {
+						case changes <- c:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+				if o.resetIndex(reported) {
+					opts.WaitIndex = 0
+					opts.WaitHash = ""
+				}
+				r.state.retried()
+				if !r.sleepBackoff(bf, delay) {
+					return
+				}
+				continue
+			}
+
+			if o.neverGiveUp {
+				releaseProbe()
+				o.handleError(&RetryError{
+					Err:         reported,
+					Attempt:     attempt,
+					Elapsed:     time.Since(failingSince),
+					NextBackoff: bf.MaxInterval,
+				})
+				opts.WaitIndex = 0
+				opts.WaitHash = ""
+				r.state.retried()
+				if !r.sleepBackoff(bf, bf.MaxInterval) {
+					return
+				}
+				continue
+			}
+
+			o.handleError(reported)
+			r.state.fail(reported)
+			return
+		}
+
+		// reset backoff after successful load
+		bf.Reset()
+		attempt, failingSince = 0, time.Time{}
+		// a reset requested while the watch was healthy must not cut a later backoff short
+		select {
+		case <-r.resetBackoff:
+		default:
+		}
+		r.state.polled()
+		w.breaker.success()
+		o.queryMeta(meta)
+		if meta.KnownLeader != knownLeader {
+			knownLeader = meta.KnownLeader
+			if o.onLeaderChange != nil {
+				o.onLeaderChange(knownLeader)
+			}
+		}
+		if escapingStale {
+			restoreStale(opts)
+			escapingStale = false
+		} else if o.escapeStale(opts, meta) {
+			escapingStale = true
+			continue
+		}
+		if o.waitHash {
+			opts.WaitHash = meta.LastContentHash
+		}
+		r.reconciler.observe(value, meta.LastIndex)
+		// an index below 1 would make the next query return immediately and the loop spin,
+		// Consul recommends to wait on 1 instead
+		index := meta.LastIndex
+		if index < 1 {
+			index = 1
+		}
+
+		changed := opts.WaitIndex != index
+		if changed && opts.WaitIndex > 0 {
+			if warning := hot.observe(time.Now(), r.src.target); warning != nil {
+				o.handleError(warning)
+			}
+		}
+		prevIdentity := lastIdentity
+		if changed && r.src.identity != nil {
+			// also compare after the index was reset by an error, so reconnects don't re-emit unchanged values
+			id := r.src.identity(value)
+			changed = !forwarded || id != lastIdentity
+			lastIdentity = id
+		}
+		// the first value emitted after waiting for existence is not debounced like a fresh start
+		appeared := false
+		if changed && !existed {
+			existed = r.src.exists(value)
+			changed, appeared = existed, existed
+		}
+		o.pollComplete(changed)
+		o.queryLatency(latency, changed)
+		if !changed {
+			opts.WaitIndex = index
+			continue
+		}
+		if r.src.validate != nil {
+			if err := r.src.validate(value); err != nil {
+				// the consumer keeps the last valid value, so it stays the one to compare with
+				o.handleError(err)
+				lastIdentity = prevIdentity
+				opts.WaitIndex = index
+				continue
+			}
+		}
+
+		c := change[T]{
+			value:       value,
+			lastIndex:   meta.LastIndex,
+			observedAt:  time.Now(),
+			stale:       o.servedStale(meta),
+			cacheHit:    meta.CacheHit,
+			lastContact: meta.LastContact,
+			// don't debounce and wait if we start fresh without wait index
+			immediate: opts.WaitIndex <= 0 || appeared,
+		}
+		select {
+		case changes <- c:
+			forwarded = true
+		case <-ctx.Done():
+			return
+		}
+		opts.WaitIndex = index
+	}
+}
+
+// change is a new value observed by the query loop
+type change[T any] struct {
+	value      T
+	lastIndex  uint64
+	observedAt time.Time
+	stale      bool
+	cacheHit   bool
+	// lastContact is the LastContact of the query
+	lastContact time.Duration
+	// fallback is set for the value of the fallback file
+	fallback bool
+	// immediate skips the debounce
+	immediate bool
+}
+
+// emit debounces changes and sends them to out. It is the only goroutine sending to out
+// and owns the debounce timer, so no send or timer can outlive it. It returns when ctx is done
+// or changes is closed, a pending debounced value is dropped in both cases unless WithCloseDrain
+// is set. Before returning it stops the timer, waits until poll stopped, closes out, unregisters
+// the watch, calls cancel and closes done. So once out is closed no query is running anymore.
+func (r *run[T, E]) emit(changes <-chan change[T]) {
+	defer close(r.done)
+	defer r.cancel()
+	defer r.w.unregister(r.state)
+	defer close(r.out)
+	defer func() {
+		// poll returns once ctx is done or it failed, it closes changes then
+		for range changes {
+		}
+	}()
+
+	ctx, o := r.ctx, r.o
+	var debounceTimer *time.Timer
+	var debounceC <-chan time.Time
+	var debounceStart time.Time
+	var pending change[T]
+	window := newDebounceWindow(o)
+	flaps := newFlapGuard(o.flapWindow, r.src)
+
+	stopTimer := func() {
+		if debounceTimer != nil {
+			debounceTimer.Stop()
+			debounceC = nil
+		}
+	}
+	defer stopTimer()
+
+	// seq numbers the delivered emissions, it is only used on this goroutine
+	var seq uint64
+	// prepare converts q into the value offered for the next emission and the commit of its wrap.
+	// It has no side effects, so it is called again for every offer and Delay covers the time a value
+	// waited in the buffer.
+	prepare := func(q queued[T]) (E, func()) {
+		meta := Meta{
+			Seq:         seq + 1,
+			LastIndex:   q.c.lastIndex,
+			Debounced:   q.debounced,
+			Delay:       time.Since(q.c.observedAt),
+			Stale:       q.c.stale,
+			CacheHit:    q.c.cacheHit,
+			LastContact: q.c.lastContact,
+			ObservedAt:  q.c.observedAt,
+			Fallback:    q.c.fallback,
+			Default:     !q.c.fallback && r.src.isDefault != nil && r.src.isDefault(q.c.value),
+		}
+
+		value := q.c.value
+		if r.src.copy != nil {
+			value = r.src.copy(value)
+		}
+		return r.wrap(value, meta)
+	}
+	// delivered records that c was received by the consumer and commits the state of its wrap
+	// exhausted is set once the maximum number of emissions was delivered, nothing is sent afterwards
+	exhausted := false
+	delivered := func(c change[T], commit func()) {
+		if commit != nil {
+			commit()
+		}
+		seq++
+		r.state.updated(c.lastIndex)
+		flaps.record(c.value)
+		if o.maxEmissions > 0 && seq >= uint64(o.maxEmissions) {
+			exhausted = true
+			r.cancel()
+		}
+	}
+
+	buffer := newEmitBuffer[T](o)
+	// deliverHead sends the oldest buffered change unless stop is closed before
+	deliverHead := func(stop <-chan struct{}) bool {
+		if exhausted {
+			return false
+		}
+		q, _ := buffer.head()
+		head, commit := prepare(q)
+
+		select {
+		case r.out <- head:
+			delivered(q.c, commit)
+			buffer.pop()
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	// send delivers c or buffers it according to the backpressure policy unless stop is closed before,
+	// a nil stop waits for the consumer
+	send := func(c change[T], debounced bool, stop <-chan struct{}) bool {
+		if exhausted {
+			return false
+		}
+		q := queued[T]{c: c, debounced: debounced}
+		if !buffer.enabled() {
+			value, commit := prepare(q)
+			select {
+			case r.out <- value:
+				delivered(c, commit)
+				return true
+			case <-stop:
+				return false
+			}
+		}
+
+		for !buffer.push(q) {
+			if !deliverHead(stop) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// flush delivers all buffered changes unless stop is closed before
+	flush := func(stop <-chan struct{}) {
+		for {
+			if _, ok := buffer.head(); !ok || !deliverHead(stop) {
+				return
+			}
+		}
+	}
+
+	// drain sends a pending debounced value and the buffered values before out is closed if WithCloseDrain is set
+	drain := func() {
+		if !o.closeDrain || exhausted {
+			return
+		}
+		if debounceC != nil && !flaps.settled(pending.value) {
+			send(pending, true, nil)
+		}
+		flush(nil)
+	}
+
+	for {
+		if exhausted {
+			return
+		}
+
+		// offer the oldest buffered change to the consumer while waiting for changes
+		var outC chan E
+		var head E
+		var commit func()
+		if q, ok := buffer.head(); ok {
+			head, commit = prepare(q)
+			outC = r.out
+		}
+
+		select {
+		case <-ctx.Done():
+			drain()
+			return
+		case outC <- head:
+			q, _ := buffer.head()
+			delivered(q.c, commit)
+			buffer.pop()
+		case c, ok := <-changes:
+			if !ok {
+				drain()
+				flush(ctx.Done())
+				return
+			}
+
+			stopTimer()
+			debounceTime := window.observe(time.Now())
+			if flaps.hold(c.value) {
+				debounceStart = time.Time{}
+				pending = c
+				debounceTimer = time.NewTimer(o.flapWindow)
+				debounceC = debounceTimer.C
+				continue
+			}
+			if c.immediate ||
+				(o.forceFlush && !debounceStart.IsZero() && time.Since(debounceStart) > 2*debounceTime) {
+				debounceStart = time.Time{}
+				if !send(c, false, ctx.Done()) {
+					return
+				}
+				continue
+			}
+
+			if debounceStart.IsZero() {
+				debounceStart = time.Now()
+			}
+			pending = c
+			debounceTimer = time.NewTimer(debounceTime)
+			debounceC = debounceTimer.C
+		case <-debounceC:
+			debounceC = nil
+			debounceStart = time.Time{}
+			c := pending
+			pending = change[T]{}
+			if flaps.settled(c.value) {
+				continue
+			}
+			if !send(c, true, ctx.Done()) {
+				return
+			}
+		}
+	}
+}
+
+// sleepBackoff waits for d like sleep after a failed query. If the backoff is reset with
+// Subscription.ResetBackoff before, it resets bf and returns true at once, so the query is retried now.
+func (r *run[T, E]) sleepBackoff(bf backoff.BackOff, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	case <-r.resetBackoff:
+		bf.Reset()
+		return true
+	}
+}
+
+// discard reads ch until it is closed, for the channel of an inner watch whose values are no longer needed
+func discard[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// sleep waits for d and returns false if ctx is done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
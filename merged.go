@@ -0,0 +1,213 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchMergedTrees watches several directories and emits a single merged view of their values keyed by
+// the path relative to their prefix. On collisions later prefixes override earlier ones, e.g. an overrides
+// prefix after a defaults prefix. A prefix is always a directory, "app" only contains the keys below "app/".
+// The first view is emitted once all prefixes were loaded, afterwards every debounced change of any prefix
+// emits the re-merged view, so deleting an override reveals the default again. Changes of different prefixes
+// within the debounce time of each other, e.g. of a transaction writing to several prefixes, are combined into
+// one view. The channel is closed after the watches of all prefixes ended.
+func (w *Watcher) WatchMergedTrees(
+	ctx context.Context, prefixes []string, opts ...WatchOption,
+) (<-chan map[string][]byte, error) {
+	dirs := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		dirs[i] = w.dirKey(prefix)
+	}
+
+	return fanInTrees(ctx, w, prefixes, opts, treeFanIn[map[string][]byte]{
+		observe: func(int, consul.KVPairs, consul.KVPairs, bool) bool {
+			return true
+		},
+		view: func(latest []consul.KVPairs) map[string][]byte {
+			merged := make(map[string][]byte)
+			for i, pairs := range latest {
+				for _, pair := range pairs {
+					if strings.HasPrefix(pair.Key, dirs[i]) && pair.Key != dirs[i] {
+						merged[strings.TrimPrefix(pair.Key, dirs[i])] = pair.Value
+					}
+				}
+			}
+			return merged
+		},
+	})
+}
+
+// WatchTreesSummary watches several directories and emits which of them changed and how, without their data.
+// The first map is emitted once all prefixes were loaded and contains every prefix with ChangeOnlyAdds, or
+// ChangeNone if it is empty. Afterwards changes of different prefixes within the debounce time of each other
+// are combined into one map that only contains the changed prefixes, several changes of the same prefix in
+// that time are combined into one kind. The channel is closed after the watches of all prefixes ended.
+func (w *Watcher) WatchTreesSummary(
+	ctx context.Context, prefixes []string, opts ...WatchOption,
+) (<-chan map[string]ChangeKind, error) {
+	// pending are the changes since the previous summary, it is only used on the goroutine of fanInTrees
+	pending := make(map[string]ChangeKind)
+	return fanInTrees(ctx, w, prefixes, opts, treeFanIn[map[string]ChangeKind]{
+		observe: func(index int, prev, pairs consul.KVPairs, initial bool) bool {
+			prefix := prefixes[index]
+			if initial {
+				// the first summary describes the trees as loaded from scratch
+				pending[prefix] = classifyChange(DiffKVPairs(nil, pairs))
+				return false
+			}
+
+			kind := classifyChange(DiffKVPairs(prev, pairs))
+			if kind == ChangeNone {
+				return false
+			}
+			pending[prefix] = combineChanges(pending[prefix], kind)
+			return true
+		},
+		view: func([]consul.KVPairs) map[string]ChangeKind {
+			summary := pending
+			pending = make(map[string]ChangeKind)
+			return summary
+		},
+	})
+}
+
+// treeFanIn describes how fanInTrees turns the snapshots of several trees into emissions
+type treeFanIn[E any] struct {
+	// observe is called for every snapshot of the tree at index with its previous snapshot, initial is set
+	// until the first emission. It returns whether the change is emitted once the debounce time elapsed.
+	observe func(index int, prev, pairs consul.KVPairs, initial bool) bool
+	// view returns the next emission for the latest snapshots of all trees
+	view func(latest []consul.KVPairs) E
+}
+
+// fanInTrees watches the trees of prefixes and emits the views of f. The first view is emitted once all trees
+// were loaded, afterwards the changes reported by observe within the debounce time of the first one are combined
+// into one view. The channel is closed after the watches of all trees ended. observe and view are only called
+// on a single goroutine.
+func fanInTrees[E any](
+	ctx context.Context, w *Watcher, prefixes []string, opts []WatchOption, f treeFanIn[E],
+) (<-chan E, error) {
+	type layer struct {
+		index int
+		pairs consul.KVPairs
+	}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	o := w.newWatchOptions(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	layers := make(chan layer)
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		pairs, err := w.WatchTree(ctx, prefix, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func(index int, pairs <-chan consul.KVPairs) {
+			defer wg.Done()
+			for p := range pairs {
+				select {
+				case layers <- layer{index: index, pairs: p}:
+				case <-ctx.Done():
+				}
+			}
+		}(i, pairs)
+	}
+
+	go func() {
+		wg.Wait()
+		close(layers)
+	}()
+
+	out := make(chan E)
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		latest := make([]consul.KVPairs, len(prefixes))
+		loaded := make([]bool, len(prefixes))
+		loading := len(prefixes)
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		emit := func() bool {
+			select {
+			case out <- f.view(latest):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case l, ok := <-layers:
+				if !ok {
+					return
+				}
+
+				prev := latest[l.index]
+				latest[l.index] = l.pairs
+				if !loaded[l.index] {
+					loaded[l.index] = true
+					loading--
+					f.observe(l.index, prev, l.pairs, true)
+					if loading == 0 && !emit() {
+						return
+					}
+					continue
+				}
+				if loading > 0 {
+					f.observe(l.index, prev, l.pairs, true)
+					continue
+				}
+				if !f.observe(l.index, prev, l.pairs, false) {
+					continue
+				}
+
+				// trees changed by the same write emit at nearly the same time, they are combined into one view.
+				// The window starts with the first change, so further changes can't delay the view.
+				if timerC == nil {
+					timer = time.NewTimer(o.debounceTime)
+					timerC = timer.C
+				}
+			case <-timerC:
+				timerC = nil
+				if !emit() {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// combineChanges returns the kind of two consecutive changes of the same tree
+func combineChanges(a, b ChangeKind) ChangeKind {
+	switch {
+	case a == ChangeAnyDelete || b == ChangeAnyDelete:
+		return ChangeAnyDelete
+	case a == ChangeNone || a == b:
+		return b
+	case b == ChangeNone:
+		return a
+	default:
+		return ChangeMixed
+	}
+}
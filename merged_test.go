@@ -0,0 +1,82 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchMergedTrees(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("defaults/host", []byte("localhost"))
+	kv.Put("defaults/port", []byte("80"))
+	kv.Put("overrides/port", []byte("8080"))
+	kv.Put("overrides-old/port", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 20*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	views, err := w.WatchMergedTrees(ctx, []string{"defaults/", "overrides"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := <-views
+	if len(view) != 2 || string(view["host"]) != "localhost" || string(view["port"]) != "8080" {
+		t.Fatalf("got %v, want host localhost and port 8080", view)
+	}
+
+	// changes of both prefixes at once emit a single view
+	kv.Put("defaults/host", []byte("db"))
+	kv.Put("overrides/host", []byte("db.internal"))
+	view = <-views
+	if string(view["host"]) != "db.internal" {
+		t.Fatalf("got host %s, want db.internal", view["host"])
+	}
+	select {
+	case view := <-views:
+		t.Fatalf("got a second view %v for the same change", view)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// deleting an override reveals the default
+	kv.Delete("overrides/host")
+	if view := <-views; string(view["host"]) != "db" {
+		t.Fatalf("got host %s, want db", view["host"])
+	}
+}
+
+func TestWatchTreesSummary(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("a/x", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 50*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	summaries, err := w.WatchTreesSummary(ctx, []string{"a/", "b/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(<-summaries); got != "map[a/:only-adds b/:none]" {
+		t.Fatalf("got %s, want a/ added and b/ empty", got)
+	}
+
+	// simultaneous changes of both prefixes are combined into one map
+	kv.Put("a/x", []byte("2"))
+	kv.Put("b/y", []byte("1"))
+	if got := fmt.Sprint(<-summaries); got != "map[a/:only-modifies b/:only-adds]" {
+		t.Fatalf("got %s, want both prefixes in one map", got)
+	}
+
+	// a change of one prefix only contains that prefix
+	kv.Delete("b/y")
+	if got := fmt.Sprint(<-summaries); got != "map[b/:any-delete]" {
+		t.Fatalf("got %s, want only b/", got)
+	}
+}
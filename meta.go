@@ -0,0 +1,245 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Meta describes how an emission was produced
+type Meta struct {
+	// Seq numbers the emissions of a watch starting at 1, it strictly increases by one per delivered emission
+	Seq uint64
+	// LastIndex is the Consul index the value was read at
+	LastIndex uint64
+	// Debounced is true if the emission was delayed by the debounce timer and false if it was sent
+	// immediately, either on the first load or by the forced flush during sustained changes
+	Debounced bool
+	// Delay is the time between reading the value from Consul and offering it to the consumer, it includes
+	// the debounce and the time the value waited in the buffer of WithBackpressure but not the time a
+	// consumer takes to receive an offered value
+	Delay time.Duration
+	// Stale is true if the value was served from the agent cache older than the max age
+	// because the servers were unavailable, see WithStaleIfError
+	Stale bool
+	// CacheHit is the CacheHit of the QueryMeta, true if the value was served by the agent cache
+	CacheHit bool
+	// LastContact is the LastContact of the QueryMeta, the time since the server that answered
+	// had contact to the leader, 0 if the leader answered
+	LastContact time.Duration
+	// ObservedAt is when the query that read the value returned
+	ObservedAt time.Time
+	// Fallback is true if the value was read from the fallback file, see WithFallbackFile
+	Fallback bool
+	// Default is true if the key doesn't exist and the value is its default, see WithDefaultValue
+	Default bool
+}
+
+// KeyWithMeta is a key value pair together with the Meta of its emission
+type KeyWithMeta struct {
+	Pair *consul.KVPair
+	// ModifyIndex is the ModifyIndex of Pair, 0 if the key doesn't exist
+	ModifyIndex uint64
+	// ModifyDelta is how far ModifyIndex advanced since the previous emission, 0 on the first emission
+	// and after the key didn't exist. Indexes are shared by all of Consul, so it is an upper bound of the writes
+	// to the key.
+	ModifyDelta uint64
+	Meta
+}
+
+// TreeWithMeta are the key value pairs of a directory together with the Meta of their emission
+type TreeWithMeta struct {
+	Pairs consul.KVPairs
+	// KeyCount is the number of keys in Pairs
+	KeyCount int
+	// TotalBytes is the sum of the value sizes of all keys in Pairs
+	TotalBytes int
+	// ModifyIndex is the highest ModifyIndex of the keys in Pairs
+	ModifyIndex uint64
+	// ModifyDelta is how far ModifyIndex advanced since the previous emission, 0 on the first emission
+	// or if it didn't advance because keys were only deleted. It is an upper bound of the writes to the tree.
+	ModifyDelta uint64
+	// Change is the kind of change of the keys since the previous emission
+	Change ChangeKind
+	Meta
+}
+
+// ChangeKind classifies how the keys of a tree changed between two emissions, e.g. to decide
+// between an incremental update and a full rebuild
+type ChangeKind int
+
+const (
+	// ChangeNone means no key was added, modified or deleted
+	ChangeNone ChangeKind = iota
+	// ChangeOnlyAdds means keys were only added, the first emission of a non-empty tree is a pure add
+	ChangeOnlyAdds
+	// ChangeOnlyModifies means keys were only modified
+	ChangeOnlyModifies
+	// ChangeMixed means keys were added and modified but none deleted
+	ChangeMixed
+	// ChangeAnyDelete means at least one key was deleted, independent of other changes
+	ChangeAnyDelete
+)
+
+// String returns the name of the change kind
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeNone:
+		return "none"
+	case ChangeOnlyAdds:
+		return "only-adds"
+	case ChangeOnlyModifies:
+		return "only-modifies"
+	case ChangeMixed:
+		return "mixed"
+	case ChangeAnyDelete:
+		return "any-delete"
+	default:
+		return "unknown"
+	}
+}
+
+// classifyChange returns the ChangeKind of the diff of two snapshots, a re-created key counts as added
+func classifyChange(created, updated, deleted consul.KVPairs) ChangeKind {
+	switch {
+	case len(deleted) > 0:
+		return ChangeAnyDelete
+	case len(created) > 0 && len(updated) > 0:
+		return ChangeMixed
+	case len(created) > 0:
+		return ChangeOnlyAdds
+	case len(updated) > 0:
+		return ChangeOnlyModifies
+	default:
+		return ChangeNone
+	}
+}
+
+// KeyUpdate is a key value pair together with the metadata of the query it was read with
+type KeyUpdate struct {
+	// Pair is the key value pair, nil if the key doesn't exist
+	Pair *consul.KVPair
+	// LastIndex is the X-Consul-Index of the response, the index the value was read at
+	LastIndex uint64
+	// CacheHit is the X-Cache header of the response, true if the agent cache served the value
+	CacheHit bool
+	// LastContact is the X-Consul-LastContact header of the response, the time since the server
+	// that answered had contact to the leader
+	LastContact time.Duration
+	// ObservedAt is the local time when the response was received
+	ObservedAt time.Time
+	// Seq numbers the updates of the watch starting at 1
+	Seq uint64
+}
+
+// WatchKeyFull works like WatchKey but emits every key value pair as a KeyUpdate
+func (w *Watcher) WatchKeyFull(ctx context.Context, key string, opts ...WatchOption) (<-chan KeyUpdate, error) {
+	o := w.newWatchOptions(opts)
+	return startWatch(ctx, w, o, w.keySource(key, o), func(pair *consul.KVPair, meta Meta) KeyUpdate {
+		return KeyUpdate{
+			Pair:        pair,
+			LastIndex:   meta.LastIndex,
+			CacheHit:    meta.CacheHit,
+			LastContact: meta.LastContact,
+			ObservedAt:  meta.ObservedAt,
+			Seq:         meta.Seq,
+		}
+	})
+}
+
+// summaryKeys is the number of key names included in a tree summary
+const summaryKeys = 3
+
+// Summary returns a compact one line description of the tree for logging, see SummarizeTree
+func (t TreeWithMeta) Summary() string {
+	return SummarizeTree(t.Pairs)
+}
+
+// SummarizeTree returns a compact one line description of pairs for logging with the number of keys,
+// the total size of their values and the first key names, e.g. "5 keys, 120 bytes: app/a, app/b, app/c, ...".
+// Values are not copied.
+func SummarizeTree(pairs consul.KVPairs) string {
+	var b strings.Builder
+	total := 0
+	for _, pair := range pairs {
+		total += len(pair.Value)
+	}
+	fmt.Fprintf(&b, "%d keys, %d bytes", len(pairs), total)
+
+	for i, pair := range pairs {
+		if i == summaryKeys {
+			b.WriteString(", ...")
+			break
+		}
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(pair.Key)
+	}
+
+	return b.String()
+}
+
+// WatchKeyWithMeta works like WatchKey but emits every key value pair together with its Meta
+func (w *Watcher) WatchKeyWithMeta(ctx context.Context, key string, opts ...WatchOption) (<-chan KeyWithMeta, error) {
+	o := w.newWatchOptions(opts)
+	var delta modifyDelta
+	wrap := func(pair *consul.KVPair, meta Meta) (KeyWithMeta, func()) {
+		km := KeyWithMeta{Pair: pair, Meta: meta}
+		if pair != nil {
+			km.ModifyIndex = pair.ModifyIndex
+		}
+		km.ModifyDelta = delta.since(km.ModifyIndex)
+		return km, func() {
+			delta.prev = km.ModifyIndex
+		}
+	}
+	return startStatefulWatch(ctx, w, o, w.keySource(key, o), wrap)
+}
+
+// WatchTreeWithMeta works like WatchTree but emits all key value pairs together with their Meta
+func (w *Watcher) WatchTreeWithMeta(
+	ctx context.Context, path string, opts ...WatchOption,
+) (<-chan TreeWithMeta, error) {
+	o := w.newWatchOptions(opts)
+	var delta modifyDelta
+	// prev is the last delivered snapshot, the Change is classified against it
+	var prev consul.KVPairs
+	wrap := func(pairs consul.KVPairs, meta Meta) (TreeWithMeta, func()) {
+		tree := TreeWithMeta{Pairs: pairs, KeyCount: len(pairs), Change: classifyChange(DiffKVPairs(prev, pairs)), Meta: meta}
+		for _, pair := range pairs {
+			tree.TotalBytes += len(pair.Value)
+			if pair.ModifyIndex > tree.ModifyIndex {
+				tree.ModifyIndex = pair.ModifyIndex
+			}
+		}
+		tree.ModifyDelta = delta.since(tree.ModifyIndex)
+		return tree, func() {
+			prev = pairs
+			delta.prev = tree.ModifyIndex
+		}
+	}
+	return startStatefulWatch(ctx, w, o, w.treeSource(path, o), wrap)
+}
+
+// modifyDelta computes the advance of the ModifyIndex between delivered emissions, wrap funcs
+// and their commits are only called on the emitter goroutine so it needs no locking
+type modifyDelta struct {
+	// prev is the ModifyIndex of the last delivered emission
+	prev uint64
+}
+
+// since returns how far index advanced since the last delivered emission, 0 if there was none
+// or the key didn't exist
+func (d *modifyDelta) since(index uint64) uint64 {
+	if d.prev > 0 && index > d.prev {
+		return index - d.prev
+	}
+
+	return 0
+}
@@ -0,0 +1,331 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestMetaSeq(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 50; i++ {
+		pair := <-pairs
+		if pair.Seq != uint64(i) {
+			t.Fatalf("got seq %d for emission %d", pair.Seq, i)
+		}
+		kv.Put("key", []byte(fmt.Sprint(i)))
+	}
+}
+
+// metaKV records the QueryMeta of every answered query
+type metaKV struct {
+	*watchertest.KV
+	metas chan *consul.QueryMeta
+}
+
+func (kv *metaKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	pair, meta, err := kv.KV.Get(key, q)
+	if err == nil {
+		meta.CacheAge = time.Duration(meta.LastIndex) * time.Second
+		kv.metas <- meta
+	}
+	return pair, meta, err
+}
+
+func TestMetaHandler(t *testing.T) {
+	checkGoroutines(t)
+	kv := &metaKV{KV: watchertest.NewKV(), metas: make(chan *consul.QueryMeta, 10)}
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	handled := make(chan *consul.QueryMeta, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithMetaHandler(func(meta *consul.QueryMeta) {
+		handled <- meta
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	// the handler is also called for the cycles woken up by writes to other keys that don't emit
+	for i := 0; i < 3; i++ {
+		want := <-kv.metas
+		if got := <-handled; got != want {
+			t.Fatalf("got meta %+v in cycle %d, want %+v", got, i, want)
+		}
+		kv.Put("other", []byte(fmt.Sprint(i)))
+	}
+}
+
+func TestModifyDelta(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyWithMeta(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string, index, delta uint64) {
+		t.Helper()
+		pair := <-pairs
+		if pair.ModifyIndex != index || pair.ModifyDelta != delta {
+			t.Fatalf("%s: got index %d delta %d, want %d and %d", step, pair.ModifyIndex, pair.ModifyDelta, index, delta)
+		}
+	}
+	check("first", 2, 0)
+	kv.Put("key", []byte("1"))
+	check("update", 3, 1)
+	// the index is shared, a write to another key advances it too
+	kv.Put("other", []byte("1"))
+	kv.Put("key", []byte("2"))
+	check("update after another write", 5, 2)
+	kv.Delete("key")
+	check("delete", 0, 0)
+	kv.Put("key", []byte("3"))
+	check("re-create", 7, 0)
+}
+
+func TestSummarizeTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs consul.KVPairs
+		want  string
+	}{
+		{name: "empty", want: "0 keys, 0 bytes"},
+		{
+			name:  "few keys",
+			pairs: consul.KVPairs{{Key: "app/a", Value: []byte("12")}, {Key: "app/b", Value: []byte("345")}},
+			want:  "2 keys, 5 bytes: app/a, app/b",
+		},
+		{
+			name: "many keys",
+			pairs: consul.KVPairs{
+				{Key: "app/a", Value: []byte("1")},
+				{Key: "app/b", Value: []byte("1")},
+				{Key: "app/c", Value: []byte("1")},
+				{Key: "app/d", Value: []byte("1")},
+				{Key: "app/e", Value: []byte("1")},
+			},
+			want: "5 keys, 5 bytes: app/a, app/b, app/c, ...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := watcher.SummarizeTree(tt.pairs); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// cachedKV answers every query like the agent cache with a fixed LastContact
+type cachedKV struct {
+	*watchertest.KV
+}
+
+func (kv cachedKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	pair, meta, err := kv.KV.Get(key, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cached := *meta
+	cached.CacheHit = true
+	cached.LastContact = 3 * time.Second
+	return pair, &cached, nil
+}
+
+func TestWatchKeyFull(t *testing.T) {
+	checkGoroutines(t)
+	kv := cachedKV{KV: watchertest.NewKV()}
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := time.Now()
+	updates, err := w.WatchKeyFull(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := <-updates
+	if update.Pair == nil || update.Pair.Key != "key" || string(update.Pair.Value) != "value" {
+		t.Fatalf("got pair %v, want key with value", update.Pair)
+	}
+	if update.LastIndex != 2 || update.Seq != 1 {
+		t.Fatalf("got index %d seq %d, want 2 and 1", update.LastIndex, update.Seq)
+	}
+	if !update.CacheHit || update.LastContact != 3*time.Second {
+		t.Fatalf("got cache hit %v last contact %s, want the mocked meta", update.CacheHit, update.LastContact)
+	}
+	if update.ObservedAt.Before(before) || update.ObservedAt.After(time.Now()) {
+		t.Fatalf("got observed at %s, want the time the query returned", update.ObservedAt)
+	}
+}
+
+// leaderKV reports KnownLeader as false while noLeader is set
+type leaderKV struct {
+	*watchertest.KV
+	noLeader int32
+}
+
+func (kv *leaderKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	pair, meta, err := kv.KV.Get(key, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	withLeader := *meta
+	withLeader.KnownLeader = atomic.LoadInt32(&kv.noLeader) == 0
+	return pair, &withLeader, nil
+}
+
+func TestLeaderChangeHandler(t *testing.T) {
+	checkGoroutines(t)
+	kv := &leaderKV{KV: watchertest.NewKV()}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	changes := make(chan bool, 10)
+	polls := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := w.WatchKey(ctx, "key",
+		watcher.WithLeaderChangeHandler(func(known bool) {
+			changes <- known
+		}),
+		watcher.WithOnPollComplete(func(changed bool) {
+			polls <- changed
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-polls
+
+	// every transition is reported once, queries with an unchanged leader state are not
+	for i, known := range []bool{false, false, true, true, false} {
+		if known {
+			atomic.StoreInt32(&kv.noLeader, 0)
+		} else {
+			atomic.StoreInt32(&kv.noLeader, 1)
+		}
+		kv.Put("other", []byte(fmt.Sprint(i)))
+		<-polls
+	}
+	close(changes)
+
+	var got []bool
+	for known := range changes {
+		got = append(got, known)
+	}
+	if want := []bool{false, true, false}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got transitions %v, want %v", got, want)
+	}
+}
+
+func TestTreeChangeKind(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	// the debounce merges the writes of a step into a single emission
+	w := watcher.New(nil, 10*time.Millisecond, 30*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTreeWithMeta(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   watcher.ChangeKind
+	}{
+		{name: "empty", want: watcher.ChangeNone},
+		{name: "add", change: func() { kv.Put("app/a", []byte("1")) }, want: watcher.ChangeOnlyAdds},
+		{name: "modify", change: func() { kv.Put("app/a", []byte("2")) }, want: watcher.ChangeOnlyModifies},
+		{
+			name: "add and modify",
+			change: func() {
+				kv.Put("app/a", []byte("3"))
+				kv.Put("app/b", []byte("1"))
+			},
+			want: watcher.ChangeMixed,
+		},
+		{
+			name: "delete and add",
+			change: func() {
+				kv.Delete("app/a")
+				kv.Put("app/c", []byte("1"))
+			},
+			want: watcher.ChangeAnyDelete,
+		},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		if tree := <-trees; tree.Change != step.want {
+			t.Fatalf("%s: got %s, want %s", step.name, tree.Change, step.want)
+		}
+	}
+}
+
+func TestTreeSize(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("12"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTreeWithMeta(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		write     func()
+		wantKeys  int
+		wantBytes int
+	}{
+		{name: "first", write: func() {}, wantKeys: 1, wantBytes: 2},
+		{name: "add", write: func() { kv.Put("app/b", []byte("345")) }, wantKeys: 2, wantBytes: 5},
+		{name: "modify", write: func() { kv.Put("app/a", []byte("1")) }, wantKeys: 2, wantBytes: 4},
+		{name: "empty value", write: func() { kv.Put("app/c", nil) }, wantKeys: 3, wantBytes: 4},
+		{name: "delete", write: func() { kv.Delete("app/b") }, wantKeys: 2, wantBytes: 1},
+	}
+	for _, s := range steps {
+		s.write()
+		tree := <-trees
+		if tree.KeyCount != s.wantKeys || tree.TotalBytes != s.wantBytes {
+			t.Fatalf("%s: got %d keys %d bytes, want %d and %d", s.name, tree.KeyCount, tree.TotalBytes, s.wantKeys, s.wantBytes)
+		}
+	}
+}
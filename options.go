@@ -0,0 +1,404 @@
+package watcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchOption configures a single watch
+type WatchOption func(o *watchOptions)
+
+// watchOptions holds the per watch configuration
+type watchOptions struct {
+	debounceTime time.Duration
+	datacenter   string
+	errorHandler func(err error)
+	neverGiveUp  bool
+	failFast     bool
+	waitHash     bool
+	onPoll       func(changed bool)
+	onMeta       func(meta *consul.QueryMeta)
+
+	waitForExistence bool
+	maxAge           time.Duration
+	staleIfError     time.Duration
+	identityFunc     func(pair *consul.KVPair) string
+	emitAbsent       bool
+	includeKeys      []string
+	flagsFilter      func(flags uint64) bool
+	startIndex       uint64
+	forceFlush       bool
+	maxLastContact   time.Duration
+	stalenessAction  StalenessAction
+	copyValues       bool
+	resyncInterval   time.Duration
+	probeOnStart     bool
+	adaptiveMin      time.Duration
+	adaptiveMax      time.Duration
+	hotKeyLimit      int
+	hotKeyWindow     time.Duration
+	resetIndexFunc   func(err error) bool
+	flapWindow       time.Duration
+	closeDrain       bool
+	deleteGrace      time.Duration
+	waitTime         time.Duration
+	backpressure     BackpressurePolicy
+	bufferSize       int
+	minPollInterval  time.Duration
+	coveringPrefix   string
+	onLeaderChange   func(known bool)
+	fallbackFile     string
+	defaultValue     []byte
+	maxEmissions     int
+	debouncer        func(in <-chan Event) <-chan Event
+	validator        func(*consul.KVPair) error
+	onLatency        func(d time.Duration, changed bool)
+	autoSplit        bool
+	reconcileEvery   time.Duration
+	treeIdentity     TreeIdentity
+	queryMutator     func(q *consul.QueryOptions)
+}
+
+// newWatchOptions returns the options of a watch with the Watcher defaults applied
+func (w *Watcher) newWatchOptions(opts []WatchOption) *watchOptions {
+	o := &watchOptions{
+		debounceTime: w.debounceTime,
+		identityFunc: pairIdentity,
+		forceFlush:   true,
+		waitTime:     time.Duration(atomic.LoadInt64(&defaultWaitTime)),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithDebounceOverride overrides the debounce time of the Watcher for a single watch
+func WithDebounceOverride(debounceTime time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.debounceTime = debounceTime
+	}
+}
+
+// inDatacenter watches in the given datacenter instead of the client default
+func inDatacenter(datacenter string) WatchOption {
+	return func(o *watchOptions) {
+		o.datacenter = datacenter
+	}
+}
+
+// WithErrorHandler sets a handler that is called on the watch goroutine for every error
+// of a query, retryable or not. It must not block. Errors after which the query is retried
+// are passed as *RetryError with the attempt count, elapsed time and next backoff.
+func WithErrorHandler(handler func(err error)) WatchOption {
+	return func(o *watchOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// WithNeverGiveUp keeps a watch running on non-retryable errors. Instead of ending the watch,
+// such an error is passed to the error handler and retried after the maximum backoff interval.
+// Retryable errors are always retried with the regular exponential backoff without a time limit.
+// It can't be combined with WithFailFast.
+func WithNeverGiveUp() WatchOption {
+	return func(o *watchOptions) {
+		o.neverGiveUp = true
+	}
+}
+
+// WithFailFast ends a watch on the first error of a query, retryable or not, instead of retrying it
+// with backoff, for crash-only consumers that let an orchestrator restart them. The error is passed
+// to the error handler and returned by Subscription.Err. It is the opposite of WithNeverGiveUp and
+// starting a watch with both options fails with ErrInvalidOptions.
+func WithFailFast() WatchOption {
+	return func(o *watchOptions) {
+		o.failFast = true
+	}
+}
+
+// WithWaitHash sends the content hash of the previous response as WaitHash with the next query,
+// so endpoints supporting hash based blocking wait for a change of the content instead of the index.
+// Consul only returns a content hash for endpoints whose state is not stored in Raft, like agent local
+// data and some cache backed endpoints. The KV endpoints return no hash, then the option has no effect
+// and the watch keeps blocking on the index.
+func WithWaitHash() WatchOption {
+	return func(o *watchOptions) {
+		o.waitHash = true
+	}
+}
+
+// WithOnPollComplete sets a callback that is called on the watch goroutine after every successful query,
+// changed reports whether the query resulted in an emission. It can be used to measure the poll
+// frequency and liveness of a watch and must not block.
+func WithOnPollComplete(fn func(changed bool)) WatchOption {
+	return func(o *watchOptions) {
+		o.onPoll = fn
+	}
+}
+
+// pollComplete calls the poll callback if one is set
+func (o *watchOptions) pollComplete(changed bool) {
+	if o.onPoll != nil {
+		o.onPoll(changed)
+	}
+}
+
+// WithQueryLatencyHandler sets a callback that is called on the watch goroutine after every query with the
+// time from sending it to receiving the response, changed reports whether the query resulted in an emission and
+// is false for failed queries. Blocking queries without a change return after about the wait time, so durations
+// clearly below it indicate changes or errors and durations at the wait time idle polls. It must not block.
+func WithQueryLatencyHandler(fn func(d time.Duration, changed bool)) WatchOption {
+	return func(o *watchOptions) {
+		o.onLatency = fn
+	}
+}
+
+// queryLatency calls the latency callback if one is set
+func (o *watchOptions) queryLatency(d time.Duration, changed bool) {
+	if o.onLatency != nil {
+		o.onLatency(d, changed)
+	}
+}
+
+// WithMetaHandler sets a callback that is called on the watch goroutine with the full QueryMeta
+// of every successful query, before it is decided whether the result is emitted. It gives access to
+// fields the package doesn't expose like KnownLeader or CacheAge and must not block.
+func WithMetaHandler(fn func(meta *consul.QueryMeta)) WatchOption {
+	return func(o *watchOptions) {
+		o.onMeta = fn
+	}
+}
+
+// queryMeta calls the meta handler if one is set
+func (o *watchOptions) queryMeta(meta *consul.QueryMeta) {
+	if o.onMeta != nil {
+		o.onMeta(meta)
+	}
+}
+
+// WithLeaderChangeHandler sets a callback that is called on the watch goroutine when the KnownLeader of the
+// query results changes, known is false while the cluster has no leader. A watch starts assuming a known leader,
+// so the callback is not called until a query reports none. It must not block.
+func WithLeaderChangeHandler(fn func(known bool)) WatchOption {
+	return func(o *watchOptions) {
+		o.onLeaderChange = fn
+	}
+}
+
+// WithQueryMutator sets a func that can change the QueryOptions of every query of the watch, e.g. to set a
+// Namespace, Partition or a field added in a later Consul version. It is called on a copy before each query.
+// The fields WaitIndex, WaitHash and WaitTime are reserved for the query loop and overwritten afterwards,
+// the context is always the one of the watch. It must not block.
+func WithQueryMutator(mutate func(q *consul.QueryOptions)) WatchOption {
+	return func(o *watchOptions) {
+		o.queryMutator = mutate
+	}
+}
+
+// mutateQuery returns opts changed by the query mutator, opts itself if none is set
+func (o *watchOptions) mutateQuery(opts *consul.QueryOptions) *consul.QueryOptions {
+	if o.queryMutator == nil {
+		return opts
+	}
+
+	q := *opts
+	o.queryMutator(&q)
+	q.WaitIndex = opts.WaitIndex
+	q.WaitHash = opts.WaitHash
+	q.WaitTime = opts.WaitTime
+	return &q
+}
+
+// WithProbeOnStart makes the watch methods run one non-blocking query before the watch is started and
+// return its error if it is not retryable, so a bad token or address fails at call time instead of
+// through the error handler. It adds the latency of a query to starting a watch.
+func WithProbeOnStart() WatchOption {
+	return func(o *watchOptions) {
+		o.probeOnStart = true
+	}
+}
+
+// WithResetIndexOnError sets the func that decides whether a retryable error resets the wait index. After a reset
+// the next query returns immediately and its value is emitted without debounce if it changed, a preserved index
+// lets the retry block again. By default every retryable error resets the index.
+func WithResetIndexOnError(reset func(err error) bool) WatchOption {
+	return func(o *watchOptions) {
+		o.resetIndexFunc = reset
+	}
+}
+
+// resetIndex reports whether the retryable error err resets the wait index
+func (o *watchOptions) resetIndex(err error) bool {
+	return o.resetIndexFunc == nil || o.resetIndexFunc(err)
+}
+
+// WithCloseDrain makes a watch send a pending debounced value before it closes its channel, instead of
+// dropping it, when its context is cancelled or it ends with an error. The send waits until the value is
+// received, so the consumer must keep receiving until the channel is closed.
+//
+// In both modes the channel is only closed after the query loop of the watch stopped, no value is sent
+// after the close and values are received in the order they were read from Consul.
+func WithCloseDrain() WatchOption {
+	return func(o *watchOptions) {
+		o.closeDrain = true
+	}
+}
+
+// WithMaxEmissions ends a watch after n values were emitted and closes its channel like a cancelled watch,
+// e.g. for tests and one-shot tools. A debounced emission of several changes counts as one.
+// A value of 0 or less doesn't limit the emissions.
+func WithMaxEmissions(n int) WatchOption {
+	return func(o *watchOptions) {
+		o.maxEmissions = n
+	}
+}
+
+// WithWaitForExistence makes a key watch wait until the key exists instead of emitting nil on the
+// first load. Once the key was emitted, a later deletion is emitted as usual.
+func WithWaitForExistence() WatchOption {
+	return func(o *watchOptions) {
+		o.waitForExistence = true
+	}
+}
+
+// WithEmitAbsent makes WatchKeyEvents emit the first state of a key even if it does not exist,
+// so consumers get a definitive absent event on startup and can apply defaults. Afterwards the watch
+// continues waiting for the key to be created. It is the opposite of WithWaitForExistence and
+// starting a watch with both options fails with ErrInvalidOptions.
+func WithEmitAbsent() WatchOption {
+	return func(o *watchOptions) {
+		o.emitAbsent = true
+	}
+}
+
+// WithIncludeKeys restricts a tree watch to exactly the given full keys below the watched path,
+// other keys are neither emitted nor considered for change detection. The key prefix of the Watcher
+// is applied to the keys like to the path.
+func WithIncludeKeys(keys ...string) WatchOption {
+	return func(o *watchOptions) {
+		o.includeKeys = append(o.includeKeys, keys...)
+	}
+}
+
+// WithFlagsFilter restricts a tree watch to the keys whose Flags satisfy filter, e.g. to watch only keys
+// tagged with a flag value within a shared prefix. Other keys are neither emitted nor considered for change
+// detection, so changing them doesn't trigger an emission. It can be combined with WithIncludeKeys.
+func WithFlagsFilter(filter func(flags uint64) bool) WatchOption {
+	return func(o *watchOptions) {
+		o.flagsFilter = filter
+	}
+}
+
+// WithStartIndex starts a watch with a blocking query on index instead of loading the current value,
+// so a watch exported with Subscription.ExportIndex can be resumed without emitting unchanged data again.
+// If Consul has compacted or reset its state since the index was exported, the query returns immediately
+// and the current value is emitted, an index from the future blocks until the wait time expires.
+func WithStartIndex(index uint64) WatchOption {
+	return func(o *watchOptions) {
+		o.startIndex = index
+	}
+}
+
+// validate checks the options for conflicts
+func (o *watchOptions) validate() error {
+	if o.emitAbsent && o.waitForExistence {
+		return fmt.Errorf("%w: WithEmitAbsent and WithWaitForExistence are mutually exclusive", ErrInvalidOptions)
+	}
+	if o.failFast && o.neverGiveUp {
+		return fmt.Errorf("%w: WithFailFast and WithNeverGiveUp are mutually exclusive", ErrInvalidOptions)
+	}
+	if err := checkWaitTime(o.waitTime); err != nil {
+		return err
+	}
+	if o.identityFunc == nil {
+		return fmt.Errorf("%w: WithIdentityFunc needs a func", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+// WithMaxAge limits how old a value served from the agent cache may be before it is fetched
+// from the servers again
+func WithMaxAge(maxAge time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithStaleIfError lets the agent cache serve values up to staleIfError old if the servers are unavailable,
+// so a watch keeps its last known value during short outages instead of retrying. It only has an effect
+// together with WithMaxAge and on endpoints supported by the agent cache. Such reads are successful
+// queries, the meta emitting watches report them with Meta.Stale.
+func WithStaleIfError(staleIfError time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.staleIfError = staleIfError
+	}
+}
+
+// servedStale reports whether a response was served from the cache only because of stale-if-error
+func (o *watchOptions) servedStale(meta *consul.QueryMeta) bool {
+	return o.staleIfError > 0 && o.maxAge > 0 && meta.CacheHit && meta.CacheAge > o.maxAge
+}
+
+// WithForceFlushOnSustainedChange toggles the forced flush of the debounce, it is enabled by default.
+// Every change restarts the debounce timer, so a value is only emitted after no change for the debounce time.
+// With the forced flush, a change arriving more than twice the debounce time after the first change
+// of a still pending debounce is emitted immediately, so sustained changes can't delay emissions forever.
+// Disabled, the debounce is strictly trailing edge and only emits after the changes settled.
+func WithForceFlushOnSustainedChange(enabled bool) WatchOption {
+	return func(o *watchOptions) {
+		o.forceFlush = enabled
+	}
+}
+
+// TreeIdentity is how a tree watch detects that a new index didn't change the tree
+type TreeIdentity int
+
+const (
+	// TreeIdentityValues hashes the keys, modify indexes and values of the tree, it is the default
+	TreeIdentityValues TreeIdentity = iota
+	// TreeIdentityIndex hashes only the keys and modify indexes, which is cheaper for large trees.
+	// Consul bumps the ModifyIndex with every write, so creates, updates and deletes are detected
+	// the same way without reading the values.
+	TreeIdentityIndex
+)
+
+// WithTreeIdentity sets how a tree watch detects index bumps that didn't change the tree
+func WithTreeIdentity(mode TreeIdentity) WatchOption {
+	return func(o *watchOptions) {
+		o.treeIdentity = mode
+	}
+}
+
+// WithIdentityFunc sets the function that computes the identity of a key value pair for change detection
+// of key watches. A new value with the same identity as the previous one is not emitted, e.g. a func that
+// normalizes JSON values prevents emissions for rewrites that don't change the meaning. The func is only called
+// for existing keys, a missing key always differs from an existing one. The default uses the ModifyIndex and value.
+// A nil func fails the start of the watch with ErrInvalidOptions.
+func WithIdentityFunc(identity func(pair *consul.KVPair) string) WatchOption {
+	return func(o *watchOptions) {
+		o.identityFunc = identity
+	}
+}
+
+// keyIdentity returns the identity of pair using the identity func, missing keys have an empty identity
+func (o *watchOptions) keyIdentity(pair *consul.KVPair) string {
+	if pair == nil {
+		return ""
+	}
+
+	// the prefix keeps an empty identity of an existing key distinct from a missing key
+	return "=" + o.identityFunc(pair)
+}
+
+// handleError passes err to the error handler if one is set
+func (o *watchOptions) handleError(err error) {
+	if o.errorHandler != nil {
+		o.errorHandler(err)
+	}
+}
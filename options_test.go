@@ -0,0 +1,88 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestIdentityFunc(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithIdentityFunc(func(pair *consul.KVPair) string {
+		return strings.ToLower(string(pair.Value))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	// a rewrite with the same identity isn't emitted
+	kv.Put("key", []byte("VALUE"))
+	kv.Put("key", []byte("other"))
+	if pair := <-pairs; string(pair.Value) != "other" {
+		t.Fatalf("got %s, want other", pair.Value)
+	}
+}
+
+func TestIdentityFuncNil(t *testing.T) {
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+	_, err := w.WatchKey(context.Background(), "key", watcher.WithIdentityFunc(nil))
+	if !errors.Is(err, watcher.ErrInvalidOptions) {
+		t.Fatalf("got %v, want ErrInvalidOptions", err)
+	}
+}
+
+// namespaceKV records the namespace and wait index of every query
+type namespaceKV struct {
+	*watchertest.KV
+	queries chan consul.QueryOptions
+}
+
+func (kv *namespaceKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	kv.queries <- *q
+	return kv.KV.Get(key, q)
+}
+
+func TestQueryMutator(t *testing.T) {
+	checkGoroutines(t)
+	kv := &namespaceKV{KV: watchertest.NewKV(), queries: make(chan consul.QueryOptions, 10)}
+	kv.Put("key", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithQueryMutator(func(q *consul.QueryOptions) {
+		q.Namespace = "team-a"
+		// reserved for the query loop
+		q.WaitIndex = 0
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	for i := 1; i <= 3; i++ {
+		q := <-kv.queries
+		if q.Namespace != "team-a" {
+			t.Fatalf("got namespace %q for query %d, want team-a", q.Namespace, i)
+		}
+		if i > 1 && q.WaitIndex == 0 {
+			t.Fatalf("got wait index 0 for query %d, want the one of the query loop", i)
+		}
+		kv.Put("key", []byte(fmt.Sprint(i)))
+		<-pairs
+	}
+}
@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchTreePatch watches for changes to a directory and emits every debounced change as a JSON merge patch
+// (RFC 7386). The patch is a flat object whose members are the changed keys relative to path, created and
+// updated keys have their value as string and deleted keys are null. A value that isn't valid UTF-8 is an object
+// with its standard base64 encoding as member "base64" instead, so binary values are not corrupted. Applying all
+// patches in order to an empty object results in the current contents of the tree, the first patch contains all
+// existing keys. A path is always a directory, "app" only contains the keys below "app/" and the key of the
+// directory itself is left out.
+func (w *Watcher) WatchTreePatch(ctx context.Context, path string, opts ...WatchOption) (<-chan []byte, error) {
+	o := w.newWatchOptions(opts)
+	snapshots, err := startWatch(ctx, w, o, w.treeSource(dirPath(path), o), valueOnly[consul.KVPairs])
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+
+		dir := w.dirKey(path)
+		diffSnapshots(snapshots, o.deleteGrace, func(created, updated, deleted consul.KVPairs) {
+			created, updated, deleted = children(created, dir), children(updated, dir), children(deleted, dir)
+			if len(created) == 0 && len(updated) == 0 && len(deleted) == 0 {
+				return
+			}
+
+			select {
+			case out <- mergePatch(dir, created, updated, deleted):
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return out, nil
+}
+
+// mergePatch returns the JSON merge patch of the results of DiffKVPairs with keys relative to prefix
+func mergePatch(prefix string, created, updated, deleted consul.KVPairs) []byte {
+	patch := make(map[string]interface{}, len(created)+len(updated)+len(deleted))
+	for _, pair := range deleted {
+		patch[strings.TrimPrefix(pair.Key, prefix)] = nil
+	}
+	for _, pairs := range []consul.KVPairs{created, updated} {
+		for _, pair := range pairs {
+			patch[strings.TrimPrefix(pair.Key, prefix)] = patchValue(pair.Value)
+		}
+	}
+
+	// strings and maps of strings can't fail to encode
+	doc, _ := json.Marshal(patch)
+	return doc
+}
+
+// patchValue returns the member of a value in a patch, a string can't hold values that aren't valid UTF-8
+// without replacing bytes, they are base64 encoded in an object instead
+func patchValue(value []byte) interface{} {
+	if utf8.Valid(value) {
+		return string(value)
+	}
+
+	return map[string]string{"base64": base64.StdEncoding.EncodeToString(value)}
+}
@@ -0,0 +1,74 @@
+package watcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchTreePatch(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/name", []byte("app"))
+	kv.Put("app/cert", []byte{0xff, 0x00, 0xfe})
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	patches, err := w.WatchTreePatch(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"cert":{"base64":"/wD+"},"name":"app"}`
+	if patch := <-patches; string(patch) != want {
+		t.Fatalf("got %s, want %s", patch, want)
+	}
+
+	kv.Delete("app/cert")
+	want = `{"cert":null}`
+	if patch := <-patches; string(patch) != want {
+		t.Fatalf("got %s, want %s", patch, want)
+	}
+
+	kv.Put("app/name", []byte(`"quoted"`))
+	var patch map[string]string
+	if err := json.Unmarshal(<-patches, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch["name"] != `"quoted"` {
+		t.Fatalf("got %q, want %q", patch["name"], `"quoted"`)
+	}
+}
+
+func TestWatchTreePatchSiblingPrefix(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("config/db", []byte("x"))
+	kv.Put("configuration/legacy", []byte("y"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the path has no trailing slash, the sibling key sharing it is not part of the directory
+	patches, err := w.WatchTreePatch(ctx, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"db":"x"}`
+	if patch := <-patches; string(patch) != want {
+		t.Fatalf("got %s, want %s", patch, want)
+	}
+
+	kv.Put("configuration/legacy", []byte("z"))
+	kv.Put("config/db", []byte("z"))
+	want = `{"db":"z"}`
+	if patch := <-patches; string(patch) != want {
+		t.Fatalf("got %s, want %s", patch, want)
+	}
+}
@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"context"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// perKeyBuffer is the number of updates buffered for every key of WatchTreePerKey
+const perKeyBuffer = 16
+
+// KeyStream is the stream of one key of WatchTreePerKey
+type KeyStream struct {
+	// Key is the full key
+	Key string
+	// Updates receives the updates of the key, the first one is the pair the key appeared with.
+	// It is closed once the key is deleted or re-created.
+	Updates <-chan *consul.KVPair
+}
+
+// WatchTreePerKey watches for changes to a directory and emits every key in its own ordered stream, e.g. for
+// consumers that shard their handling by key. The returned channel announces the stream of every key once the
+// key appears. Once a key is deleted its stream is closed, a key deleted and re-created between two snapshots
+// also closes its stream and is announced again with a new one. Every stream is only handed out with its
+// announcement, so a consumer never receives the updates of a later generation of a key on an old stream.
+// Every key buffers up to 16 updates, once a buffer is full the watch waits for its consumer, so all streams
+// must be drained. All streams are closed when the watch ends.
+func (w *Watcher) WatchTreePerKey(ctx context.Context, path string, opts ...WatchOption) (<-chan KeyStream, error) {
+	o := w.newWatchOptions(opts)
+	snapshots, err := startWatch(ctx, w, o, w.treeSource(path, o), valueOnly[consul.KVPairs])
+	if err != nil {
+		return nil, err
+	}
+
+	announce := make(chan KeyStream)
+	go func() {
+		defer close(announce)
+
+		// streams is only used on this goroutine
+		streams := make(map[string]chan *consul.KVPair)
+		remove := func(key string) {
+			if stream, ok := streams[key]; ok {
+				close(stream)
+				delete(streams, key)
+			}
+		}
+		defer func() {
+			for key := range streams {
+				remove(key)
+			}
+		}()
+
+		diffSnapshots(snapshots, o.deleteGrace, func(created, updated, deleted consul.KVPairs) {
+			for _, pair := range deleted {
+				remove(pair.Key)
+			}
+			for _, pair := range updated {
+				if stream, ok := streams[pair.Key]; ok {
+					select {
+					case stream <- pair:
+					case <-ctx.Done():
+					}
+				}
+			}
+			for _, pair := range created {
+				// a re-created key gets a new stream
+				remove(pair.Key)
+				stream := make(chan *consul.KVPair, perKeyBuffer)
+				stream <- pair
+				streams[pair.Key] = stream
+
+				select {
+				case announce <- KeyStream{Key: pair.Key, Updates: stream}:
+				case <-ctx.Done():
+				}
+			}
+		})
+	}()
+
+	return announce, nil
+}
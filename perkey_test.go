@@ -0,0 +1,58 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchTreePerKey(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streams, err := w.WatchTreePerKey(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := <-streams
+	if a.Key != "app/a" {
+		t.Fatalf("got stream of %s, want app/a", a.Key)
+	}
+	if pair := <-a.Updates; string(pair.Value) != "1" {
+		t.Fatalf("got %s, want 1", pair.Value)
+	}
+
+	kv.Put("app/a", []byte("2"))
+	if pair := <-a.Updates; string(pair.Value) != "2" {
+		t.Fatalf("got %s, want 2", pair.Value)
+	}
+
+	// a deleted key closes its stream, the re-created key is announced with a new one
+	kv.Delete("app/a")
+	if _, ok := <-a.Updates; ok {
+		t.Fatal("got update on the stream of a deleted key")
+	}
+	kv.Put("app/a", []byte("3"))
+	recreated := <-streams
+	if recreated.Key != "app/a" || recreated.Updates == a.Updates {
+		t.Fatalf("got stream of %s, want a new stream of app/a", recreated.Key)
+	}
+	if pair := <-recreated.Updates; string(pair.Value) != "3" {
+		t.Fatalf("got %s, want 3", pair.Value)
+	}
+
+	cancel()
+	for range streams {
+	}
+	if _, ok := <-recreated.Updates; ok {
+		t.Fatal("stream not closed after the watch ended")
+	}
+}
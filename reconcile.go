@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ErrReconciled is reported to the error handler if the consistent reconcile found a newer value than
+// the stale reads of the watch, see WithConsistentReconcile
+var ErrReconciled = errors.New("reconciled stale watch")
+
+// WithConsistentReconcile makes a watch compare its state with a consistent read served by the leader every
+// interval plus up to 10% of jitter, as a safeguard against a follower that serves stale results for a long
+// time without losing contact to the leader. The read runs next to the blocking query of the watch, so reads
+// stay stale otherwise. If the leader has a different value at a newer index, ErrReconciled is passed to the
+// error handler, the blocking query is interrupted and the watch re-reads the value consistently without wait
+// index and emits it. A failed reconcile read is not reported, it is retried at the next interval.
+// Watches of single keys without identity compare the key, modify index and value.
+func WithConsistentReconcile(interval time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.reconcileEvery = interval
+	}
+}
+
+// reconciler is the shared state of the query loop and the consistent reconcile of a watch
+type reconciler[T any] struct {
+	identity func(T) string
+
+	mu sync.Mutex
+	// id and index are the identity and index of the last successful query of the query loop
+	id    string
+	index uint64
+	known bool
+	// interrupt cancels the running query of the query loop, nil while none is running
+	interrupt context.CancelFunc
+	// correction is set once a divergence was found until the query loop realigned
+	correction error
+}
+
+// newReconciler returns the reconciler for src with the interval of o, nil if it is disabled or src
+// has no identity to compare values with
+func newReconciler[T any](o *watchOptions, src source[T]) *reconciler[T] {
+	identity := src.identity
+	if identity == nil {
+		identity = src.hash
+	}
+	if o.reconcileEvery <= 0 || identity == nil {
+		return nil
+	}
+
+	return &reconciler[T]{identity: identity}
+}
+
+// queryContext returns the context for the next query of the query loop, which the reconciler cancels to
+// interrupt it, and the pending correction that must be applied before the query, nil if there is none
+func (rc *reconciler[T]) queryContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if rc == nil {
+		return ctx, func() {}, nil
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	correction := rc.correction
+	rc.correction = nil
+	queryCtx, cancel := context.WithCancel(ctx)
+	rc.interrupt = cancel
+
+	return queryCtx, func() {
+		rc.mu.Lock()
+		rc.interrupt = nil
+		rc.mu.Unlock()
+		cancel()
+	}, correction
+}
+
+// observe records the result of a successful query of the query loop
+func (rc *reconciler[T]) observe(value T, index uint64) {
+	if rc == nil {
+		return
+	}
+
+	id := rc.identity(value)
+	rc.mu.Lock()
+	rc.id, rc.index, rc.known = id, index, true
+	rc.mu.Unlock()
+}
+
+// compare checks the result of a consistent read against the last result of the query loop. If the leader has
+// a different value at a newer index, it requests a consistent read of the query loop and interrupts its query.
+func (rc *reconciler[T]) compare(value T, index uint64, consistentNext *int32) {
+	id := rc.identity(value)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !rc.known || id == rc.id || index <= rc.index {
+		return
+	}
+
+	rc.correction = fmt.Errorf("%w: leader is at index %d, the watch at %d", ErrReconciled, index, rc.index)
+	atomic.StoreInt32(consistentNext, 1)
+	if rc.interrupt != nil {
+		rc.interrupt()
+	}
+}
+
+// reconcile runs the consistent reads of the reconciler until ctx is done
+func (r *run[T, E]) reconcile(ctx context.Context) {
+	o := r.o
+	for {
+		jitter := time.Duration(rand.Float64() * resyncJitter * float64(o.reconcileEvery))
+		if !sleep(ctx, o.reconcileEvery+jitter) {
+			return
+		}
+
+		if err := r.w.requests.acquire(ctx); err != nil {
+			return
+		}
+		opts := &consul.QueryOptions{
+			RequireConsistent: true,
+			Datacenter:        o.datacenter,
+		}
+		value, meta, err := r.src.fetch(o.mutateQuery(opts).WithContext(ctx))
+		r.w.requests.release()
+		if err != nil {
+			continue
+		}
+
+		r.reconciler.compare(value, meta.LastIndex, &r.consistentNext)
+	}
+}
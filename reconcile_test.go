@@ -0,0 +1,67 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// staleKV is a follower stuck at pair, only consistent reads see the current state of the KV
+type staleKV struct {
+	*watchertest.KV
+	pair *consul.KVPair
+}
+
+func (kv *staleKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if q.RequireConsistent {
+		return kv.KV.Get(key, q)
+	}
+	if q.WaitIndex >= kv.pair.ModifyIndex {
+		<-q.Context().Done()
+		return nil, nil, q.Context().Err()
+	}
+
+	return kv.pair, &consul.QueryMeta{LastIndex: kv.pair.ModifyIndex, KnownLeader: true}, nil
+}
+
+func TestConsistentReconcile(t *testing.T) {
+	checkGoroutines(t)
+	fake := watchertest.NewKV()
+	fake.Put("key", []byte("old"))
+	old, _, err := fake.Get("key", &consul.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Put("key", []byte("new"))
+	kv := &staleKV{KV: fake, pair: old}
+
+	errs := make(chan error, 10)
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithConsistentReconcile(20*time.Millisecond),
+		watcher.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pair := <-pairs; string(pair.Value) != "old" {
+		t.Fatalf("got %s, want the stale old", pair.Value)
+	}
+	if pair := <-pairs; string(pair.Value) != "new" {
+		t.Fatalf("got %s, want the reconciled new", pair.Value)
+	}
+	if err := <-errs; !errors.Is(err, watcher.ErrReconciled) {
+		t.Fatalf("got %v, want ErrReconciled", err)
+	}
+}
@@ -0,0 +1,205 @@
+package watcher
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WatchKind is the kind of a watch
+type WatchKind string
+
+const (
+	// KindKey watches a single key
+	KindKey WatchKind = "key"
+	// KindTree watches all keys below a path
+	KindTree WatchKind = "tree"
+	// KindTxn watches the result of a read-only transaction
+	KindTxn WatchKind = "txn"
+	// KindIntentions watches the Connect intentions, Target is the service they are filtered by
+	KindIntentions WatchKind = "intentions"
+)
+
+// WatchInfo describes a watch of a Watcher
+type WatchInfo struct {
+	Kind WatchKind
+	// Target is the watched key or path
+	Target string
+	// Datacenter is the datacenter the watch queries, empty for the datacenter of the agent
+	Datacenter string
+	StartedAt  time.Time
+	LastUpdate time.Time
+	// LastSuccessfulPoll is when the last successful query returned, also if it didn't change the value.
+	// A watch whose LastSuccessfulPoll falls behind, e.g. while it is stuck in backoff, has stopped
+	// receiving updates from Consul. It is zero until the first successful query.
+	LastSuccessfulPoll time.Time
+	// Emissions is the number of values emitted by the watch
+	Emissions uint64
+	// Retries is the number of queries retried after an error
+	Retries uint64
+	// Err is the terminal error of a watch that has died, watches that ended because
+	// their context was cancelled are no longer listed
+	Err error
+}
+
+// maxFailedWatches is the number of watches that died with an error that are kept for List,
+// the oldest are removed once more watches died
+const maxFailedWatches = 100
+
+// List returns all active watches of the Watcher and the last 100 that died with an error, sorted by start time.
+// It is safe to call while watches start and stop.
+func (w *Watcher) List() []WatchInfo {
+	w.watchesMu.Lock()
+	infos := make([]WatchInfo, 0, len(w.watches))
+	for state := range w.watches {
+		infos = append(infos, state.info())
+	}
+	w.watchesMu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+
+	return infos
+}
+
+// counters are the totals of all watches of a Watcher, they are updated atomically
+type counters struct {
+	emissions uint64
+	retries   uint64
+}
+
+// watchState is the shared state of a running watch
+type watchState struct {
+	kind       WatchKind
+	target     string
+	datacenter string
+	startedAt  time.Time
+	totals     *counters
+
+	mu         sync.Mutex
+	lastUpdate time.Time
+	lastPoll   time.Time
+	emissions  uint64
+	retries    uint64
+	lastIndex  uint64
+	endedAt    time.Time
+	err        error
+}
+
+// info returns the WatchInfo of the watch
+func (s *watchState) info() WatchInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return WatchInfo{
+		Kind:               s.kind,
+		Target:             s.target,
+		Datacenter:         s.datacenter,
+		StartedAt:          s.startedAt,
+		LastUpdate:         s.lastUpdate,
+		LastSuccessfulPoll: s.lastPoll,
+		Emissions:          s.emissions,
+		Retries:            s.retries,
+		Err:                s.err,
+	}
+}
+
+// summary returns the WatchSummary of the watch
+func (s *watchState) summary() WatchSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := s.endedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	return WatchSummary{
+		Emissions:          s.emissions,
+		Retries:            s.retries,
+		Uptime:             end.Sub(s.startedAt),
+		LastSuccessfulPoll: s.lastPoll,
+		Err:                s.err,
+	}
+}
+
+// updated records an emission of a value read at index
+func (s *watchState) updated(index uint64) {
+	s.mu.Lock()
+	s.lastUpdate = time.Now()
+	s.lastIndex = index
+	s.emissions++
+	s.mu.Unlock()
+	atomic.AddUint64(&s.totals.emissions, 1)
+}
+
+// polled records a successful query
+func (s *watchState) polled() {
+	s.mu.Lock()
+	s.lastPoll = time.Now()
+	s.mu.Unlock()
+}
+
+// index returns the index of the last emitted value
+func (s *watchState) index() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastIndex
+}
+
+// retried records a retry after an error
+func (s *watchState) retried() {
+	s.mu.Lock()
+	s.retries++
+	s.mu.Unlock()
+	atomic.AddUint64(&s.totals.retries, 1)
+}
+
+// fail records the terminal error of the watch
+func (s *watchState) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// register adds a new watch to the watches of the Watcher
+func (w *Watcher) register(kind WatchKind, target, datacenter string) *watchState {
+	state := &watchState{
+		kind:       kind,
+		target:     target,
+		datacenter: datacenter,
+		startedAt:  time.Now(),
+		totals:     &w.totals,
+	}
+
+	w.watchesMu.Lock()
+	w.watches[state] = struct{}{}
+	w.watchesMu.Unlock()
+
+	return state
+}
+
+// unregister records the end of a watch and removes it if it ended without error,
+// a watch that died is kept to report its error until maxFailedWatches newer ones died
+func (w *Watcher) unregister(state *watchState) {
+	state.mu.Lock()
+	state.endedAt = time.Now()
+	failed := state.err != nil
+	state.mu.Unlock()
+
+	w.watchesMu.Lock()
+	defer w.watchesMu.Unlock()
+	if !failed {
+		delete(w.watches, state)
+		return
+	}
+
+	w.failed = append(w.failed, state)
+	if len(w.failed) > maxFailedWatches {
+		delete(w.watches, w.failed[0])
+		w.failed[0] = nil
+		w.failed = w.failed[1:]
+	}
+}
@@ -0,0 +1,71 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestListKeepsLastFailedWatches(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.SetError(errors.New("failed"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	for i := 0; i < 120; i++ {
+		pairs, err := w.WatchKey(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range pairs {
+		}
+	}
+
+	// a watch is unregistered right after its channel was closed
+	waitFor(t, func() bool {
+		return len(w.List()) == 100
+	})
+	for _, info := range w.List() {
+		if info.Err == nil {
+			t.Fatalf("got watch %v without error", info)
+		}
+	}
+}
+
+func TestListLastSuccessfulPoll(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	polls := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithOnPollComplete(func(changed bool) {
+		polls <- changed
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+	<-polls
+
+	last := w.List()[0].LastSuccessfulPoll
+	if last.IsZero() {
+		t.Fatal("got no last successful poll after the first query")
+	}
+
+	// a poll without change advances the timestamp too
+	time.Sleep(5 * time.Millisecond)
+	kv.Put("other", []byte("1"))
+	if changed := <-polls; changed {
+		t.Fatal("got a change for a write to another key")
+	}
+	if poll := w.List()[0].LastSuccessfulPoll; !poll.After(last) {
+		t.Fatalf("got last successful poll %s, want it after %s", poll, last)
+	}
+}
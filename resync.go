@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"math/rand"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// resyncJitter is the maximum fraction of the resync interval that is added randomly to every interval
+const resyncJitter = 0.1
+
+// WithPeriodicResync makes a watch re-read its value without wait index every interval, as a safeguard
+// against missed updates and followers stuck on an old index. Up to 10% of jitter is added to every interval
+// so a fleet of watchers doesn't resync at the same time. A resync that finds the same value doesn't emit.
+func WithPeriodicResync(interval time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.resyncInterval = interval
+	}
+}
+
+// WithMinPollInterval enforces a minimum interval between the starts of consecutive blocking queries of a watch.
+// A blocking query that returns faster, e.g. because the index keeps advancing from churn or a misbehaving server,
+// is followed by a delay of the remaining interval plus up to 10% of jitter, so the watch can't turn into a tight loop.
+// Queries without wait index, like the first one and retries after an index reset, are not delayed.
+func WithMinPollInterval(d time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.minPollInterval = d
+	}
+}
+
+// pollSpacing returns how long to wait before the next query to keep the minimum poll interval since last
+func (o *watchOptions) pollSpacing(opts *consul.QueryOptions, last time.Time) time.Duration {
+	if o.minPollInterval <= 0 || opts.WaitIndex == 0 || last.IsZero() {
+		return 0
+	}
+
+	remaining := o.minPollInterval - time.Since(last)
+	if remaining <= 0 {
+		return 0
+	}
+	return remaining + time.Duration(rand.Float64()*resyncJitter*float64(o.minPollInterval))
+}
+
+// resync schedules the periodic resync of a watch
+type resync struct {
+	interval time.Duration
+	waitTime time.Duration
+	next     time.Time
+}
+
+// newResync returns the resync schedule for interval of a watch with waitTime, a zero interval disables it
+func newResync(interval, waitTime time.Duration) *resync {
+	r := &resync{interval: interval, waitTime: waitTime}
+	r.schedule()
+	return r
+}
+
+// schedule sets the time of the next resync
+func (r *resync) schedule() {
+	if r.interval <= 0 {
+		return
+	}
+
+	jitter := time.Duration(rand.Float64() * resyncJitter * float64(r.interval))
+	r.next = time.Now().Add(r.interval + jitter)
+}
+
+// prepare is called before every query. Once the resync is due it resets the wait index, otherwise it
+// shortens the wait time of a blocking query so it returns in time for the next resync.
+func (r *resync) prepare(opts *consul.QueryOptions) {
+	opts.WaitTime = r.waitTime
+	if r.interval <= 0 {
+		return
+	}
+
+	remaining := time.Until(r.next)
+	if remaining <= 0 {
+		opts.WaitIndex = 0
+		opts.WaitHash = ""
+		r.schedule()
+		return
+	}
+	if remaining < opts.WaitTime {
+		opts.WaitTime = remaining
+	}
+}
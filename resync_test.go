@@ -0,0 +1,93 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// resyncKV records when queries without wait index are started
+type resyncKV struct {
+	*watchertest.KV
+	reads chan time.Time
+}
+
+func (kv *resyncKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if q.WaitIndex == 0 {
+		kv.reads <- time.Now()
+	}
+	return kv.KV.Get(key, q)
+}
+
+func TestPeriodicResync(t *testing.T) {
+	checkGoroutines(t)
+	kv := &resyncKV{KV: watchertest.NewKV(), reads: make(chan time.Time, 10)}
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithPeriodicResync(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	// every resync re-reads the value without wait index after the interval plus up to 10% jitter
+	last := <-kv.reads
+	for i := 0; i < 2; i++ {
+		select {
+		case read := <-kv.reads:
+			if gap := read.Sub(last); gap < 50*time.Millisecond {
+				t.Fatalf("got resync after %s, want at least the interval", gap)
+			}
+			last = read
+		case pair := <-pairs:
+			t.Fatalf("got emission %s for a resync of the unchanged value", pair.Value)
+		case <-time.After(time.Second):
+			t.Fatal("got no resync")
+		}
+	}
+}
+
+// churnKV returns every query immediately with an advanced index and records when blocking queries start
+type churnKV struct {
+	*watchertest.KV
+	starts chan time.Time
+}
+
+func (kv *churnKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if q.WaitIndex > 0 {
+		select {
+		case kv.starts <- time.Now():
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+	}
+	return nil, &consul.QueryMeta{LastIndex: q.WaitIndex + 1, KnownLeader: true}, nil
+}
+
+func TestMinPollInterval(t *testing.T) {
+	checkGoroutines(t)
+	kv := &churnKV{KV: watchertest.NewKV(), starts: make(chan time.Time)}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := w.WatchKey(ctx, "key", watcher.WithMinPollInterval(30*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	last := <-kv.starts
+	for i := 0; i < 3; i++ {
+		start := <-kv.starts
+		if gap := start.Sub(last); gap < 30*time.Millisecond {
+			t.Fatalf("got blocking queries %s apart, want at least 30ms", gap)
+		}
+		last = start
+	}
+}
@@ -0,0 +1,42 @@
+package watcher
+
+import "context"
+
+// WithMaxConcurrentRequests limits the number of Consul queries that all watches of the Watcher
+// have in flight at the same time to n, further queries wait for a free slot.
+//
+// Blocking queries hold their slot until Consul returns, which is up to the wait time if nothing changes.
+// With more watches than slots, changes to watches waiting for a slot are only seen once another
+// blocking query returns, so n should be close to the number of watches and mainly protect against
+// bursts, e.g. when all watches reconnect after an outage at once.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(w *Watcher) {
+		if n > 0 {
+			w.requests = make(semaphore, n)
+		}
+	}
+}
+
+// semaphore limits concurrent requests, a nil semaphore does not limit anything
+type semaphore chan struct{}
+
+// acquire waits for a free slot or until ctx is done
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot acquired before
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
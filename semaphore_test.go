@@ -0,0 +1,144 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// blockingKV blocks every Get and List until the query is cancelled and records the queries in flight
+type blockingKV struct {
+	*watchertest.KV
+
+	mu sync.Mutex
+	// inFlight are the keys and paths of the queries in flight
+	inFlight map[string]bool
+	max      int
+}
+
+func (kv *blockingKV) block(key string, q *consul.QueryOptions) (*consul.QueryMeta, error) {
+	kv.mu.Lock()
+	kv.inFlight[key] = true
+	if len(kv.inFlight) > kv.max {
+		kv.max = len(kv.inFlight)
+	}
+	kv.mu.Unlock()
+
+	<-q.Context().Done()
+
+	kv.mu.Lock()
+	delete(kv.inFlight, key)
+	kv.mu.Unlock()
+	return nil, q.Context().Err()
+}
+
+func (kv *blockingKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	meta, err := kv.block(key, q)
+	return nil, meta, err
+}
+
+func (kv *blockingKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	meta, err := kv.block(prefix, q)
+	return nil, meta, err
+}
+
+// queries returns the keys and paths of the queries in flight and the most that were in flight at once
+func (kv *blockingKV) queries() (inFlight []string, max int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for key := range kv.inFlight {
+		inFlight = append(inFlight, key)
+	}
+	return inFlight, kv.max
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	checkGoroutines(t)
+	kv := &blockingKV{KV: watchertest.NewKV(), inFlight: make(map[string]bool)}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv), watcher.WithMaxConcurrentRequests(2))
+
+	// more key and tree watches than slots are started
+	watches := make(map[string]context.CancelFunc)
+	var cancels []context.CancelFunc
+	var done []func()
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		for _, wait := range done {
+			wait()
+		}
+	}()
+	for i := 0; i < 6; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+
+		if i%2 == 0 {
+			key := fmt.Sprintf("key%d", i)
+			watches[key] = cancel
+			pairs, err := w.WatchKey(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = append(done, func() {
+				for range pairs {
+				}
+			})
+			continue
+		}
+		path := fmt.Sprintf("tree%d/", i)
+		watches[path] = cancel
+		trees, err := w.WatchTree(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = append(done, func() {
+			for range trees {
+			}
+		})
+	}
+
+	// the watches holding a slot are cancelled twice, every time waiting watches take over their slots
+	for round := 0; round < 2; round++ {
+		var inFlight []string
+		waitFor(t, func() bool {
+			inFlight, _ = kv.queries()
+			for _, key := range inFlight {
+				if watches[key] == nil {
+					// the query of a cancelled watch didn't return yet
+					return false
+				}
+			}
+			return len(inFlight) == 2
+		})
+		time.Sleep(30 * time.Millisecond)
+		if _, max := kv.queries(); max != 2 {
+			t.Fatalf("got %d queries in flight, want at most 2", max)
+		}
+		for _, key := range inFlight {
+			watches[key]()
+			delete(watches, key)
+		}
+	}
+	waitFor(t, func() bool {
+		inFlight, _ := kv.queries()
+		return len(inFlight) == 2 && watches[inFlight[0]] != nil && watches[inFlight[1]] != nil
+	})
+
+	for _, cancel := range watches {
+		cancel()
+	}
+	waitFor(t, func() bool {
+		inFlight, _ := kv.queries()
+		return len(inFlight) == 0 && len(w.List()) == 0
+	})
+	if _, max := kv.queries(); max != 2 {
+		t.Fatalf("got %d queries in flight, want at most 2", max)
+	}
+}
@@ -0,0 +1,203 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestShutdownPendingValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []watcher.WatchOption
+		want  []string
+		drain bool
+	}{
+		{name: "dropped", want: nil},
+		{name: "drained", opts: []watcher.WatchOption{watcher.WithCloseDrain()}, want: []string{"v2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("key", []byte("v1"))
+			w := watcher.New(nil, 10*time.Millisecond, time.Hour, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pair := <-pairs; string(pair.Value) != "v1" {
+				t.Fatalf("first value %q, want v1", pair.Value)
+			}
+
+			// the update is pending in the debounce of an hour when the watch is cancelled
+			kv.Put("key", []byte("v2"))
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			var got []string
+			for pair := range pairs {
+				got = append(got, string(pair.Value))
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Fatalf("values after cancel %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownClosedAfterQueryLoop(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 10*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := w.SubscribeKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Updates()
+	cancel()
+
+	for range sub.Updates() {
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("done not closed after the channel was closed")
+	}
+	if err := sub.Err(); err != nil {
+		t.Fatalf("cancelled watch ended with %v", err)
+	}
+	if n := len(w.List()); n != 0 {
+		t.Fatalf("%d watches listed after the watch ended", n)
+	}
+}
+
+func TestShutdownIndirectDrain(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("pointer", []byte("a"))
+	kv.Put("a", []byte("1"))
+	kv.Put("b", []byte("2"))
+	w := watcher.New(nil, 10*time.Millisecond, time.Hour, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKeyIndirect(ctx, "pointer", watcher.WithCloseDrain())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; string(pair.Value) != "1" {
+		t.Fatalf("first value %q, want 1", pair.Value)
+	}
+
+	// the target has a pending value when the pointer moves away from it
+	kv.Put("a", []byte("1b"))
+	time.Sleep(50 * time.Millisecond)
+	kv.Put("pointer", []byte("b"))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	for range pairs {
+	}
+}
+
+func TestShutdownTerminalError(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			errs := make(chan error, 10)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			sub, err := w.SubscribeKey(ctx, "key", watcher.WithErrorHandler(func(err error) {
+				errs <- err
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range sub.Updates() {
+			}
+			<-sub.Done()
+
+			if err := sub.Err(); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			close(errs)
+			var handled error
+			for err := range errs {
+				handled = err
+			}
+			if !errors.Is(handled, tt.want) {
+				t.Fatalf("got %v passed to the error handler, want %v", handled, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownDoneAfterClose(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("v1"))
+	w := watcher.New(nil, 10*time.Millisecond, time.Hour, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := w.SubscribeKey(ctx, "key", watcher.WithCloseDrain())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Updates()
+
+	// the drained value is still waiting for the consumer after the cancel
+	kv.Put("key", []byte("v2"))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-sub.Done():
+		t.Fatal("done closed while a value is still waiting to be received")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	if pair := <-sub.Updates(); string(pair.Value) != "v2" {
+		t.Fatalf("got %s, want the drained v2", pair.Value)
+	}
+	if _, ok := <-sub.Updates(); ok {
+		t.Fatal("got a value after the drained one")
+	}
+	<-sub.Done()
+	// no goroutine of the watch is left once done is closed, checked by checkGoroutines
+}
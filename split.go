@@ -0,0 +1,107 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WithAutoSplitOnOversize makes a tree watch whose response exceeds a size limit of Consul split itself into
+// its sub-prefixes instead of ending with ErrResponseTooLarge. Once split, the watch blocks on the keys of the
+// first level below the path, which change whenever the tree changes, and then reads every sub-prefix and
+// every key of the first level separately and merges them into one emission. Sub-prefixes that are too large
+// themselves are split further. The reads of a split watch are not an atomic snapshot of the tree.
+// ErrResponseTooLarge is passed to the error handler as a warning when the watch is split. A split watch
+// tries to list the whole tree again every five minutes and stays unsplit once that succeeds.
+func WithAutoSplitOnOversize() WatchOption {
+	return func(o *watchOptions) {
+		o.autoSplit = true
+	}
+}
+
+// splitRetryInterval is the interval after which a split tree tries to list the whole tree again
+var splitRetryInterval = 5 * time.Minute
+
+// splitTree lists a tree and switches to reading its sub-prefixes separately once the tree is too large
+type splitTree struct {
+	kv   KVClient
+	path string
+	o    *watchOptions
+	// retry is the interval after which a split tree lists the whole tree again
+	retry time.Duration
+	// splitAt is the time in unix nanoseconds the tree was split, 0 if it isn't, it is accessed atomically
+	splitAt int64
+}
+
+// list reads all keys below the path of the tree with opts
+func (s *splitTree) list(opts *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	splitAt := atomic.LoadInt64(&s.splitAt)
+	if splitAt == 0 || time.Since(time.Unix(0, splitAt)) >= s.retry {
+		pairs, meta, err := s.kv.List(s.path, opts)
+		if err == nil {
+			atomic.StoreInt64(&s.splitAt, 0)
+		}
+		if err == nil || !isOversized(err) {
+			return pairs, meta, err
+		}
+
+		atomic.StoreInt64(&s.splitAt, time.Now().UnixNano())
+		if splitAt == 0 {
+			s.o.handleError(fmt.Errorf("%w: splitting the watch of %s into its sub-prefixes", ErrResponseTooLarge, s.path))
+		}
+	}
+
+	keys, meta, err := s.kv.Keys(s.path, "/", opts)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	// the blocking query returned, the parts are read at their current index
+	read := *opts
+	read.WaitIndex = 0
+	read.WaitHash = ""
+	read.WaitTime = 0
+	pairs, err := s.read(s.path, keys, &read)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	return pairs, meta, nil
+}
+
+// read reads the keys returned by a keys query for prefix with the separator "/", listing sub-prefixes
+// and splitting them further if they are too large
+func (s *splitTree) read(prefix string, keys []string, opts *consul.QueryOptions) (consul.KVPairs, error) {
+	pairs := consul.KVPairs{}
+	for _, key := range keys {
+		if key == prefix || !strings.HasSuffix(key, "/") {
+			pair, _, err := s.kv.Get(key, opts)
+			if err != nil {
+				return nil, err
+			}
+			if pair != nil {
+				pairs = append(pairs, pair)
+			}
+			continue
+		}
+
+		sub, _, err := s.kv.List(key, opts)
+		if err != nil && isOversized(err) {
+			var subKeys []string
+			subKeys, _, err = s.kv.Keys(key, "/", opts)
+			if err == nil {
+				sub, err = s.read(key, subKeys, opts)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, sub...)
+	}
+	sortPairs(pairs)
+
+	return pairs, nil
+}
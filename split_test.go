@@ -0,0 +1,172 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// oversizedKV rejects lists of prefix with a 413 response while oversized is set
+type oversizedKV struct {
+	*watchertest.KV
+	prefix string
+
+	mu        sync.Mutex
+	oversized bool
+	lists     int
+}
+
+func (kv *oversizedKV) setOversized(oversized bool) {
+	kv.mu.Lock()
+	kv.oversized = oversized
+	kv.mu.Unlock()
+}
+
+func (kv *oversizedKV) wholeLists() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.lists
+}
+
+func (kv *oversizedKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	if prefix == kv.prefix {
+		kv.mu.Lock()
+		oversized := kv.oversized
+		if !oversized {
+			kv.lists++
+		}
+		kv.mu.Unlock()
+		if oversized {
+			return nil, nil, consul.StatusError{Code: http.StatusRequestEntityTooLarge, Body: "response too large"}
+		}
+	}
+
+	return kv.KV.List(prefix, q)
+}
+
+func newOversizedKV() *oversizedKV {
+	kv := &oversizedKV{KV: watchertest.NewKV(), prefix: "app/", oversized: true}
+	kv.Put("app/name", []byte("app"))
+	kv.Put("app/db/host", []byte("localhost"))
+	kv.Put("app/db/port", []byte("5432"))
+	return kv
+}
+
+func treeKeys(pairs consul.KVPairs) []string {
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	return keys
+}
+
+func TestAutoSplitOnOversize(t *testing.T) {
+	checkGoroutines(t)
+	kv := newOversizedKV()
+
+	var mu sync.Mutex
+	var warnings []error
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchTree(ctx, "app/", watcher.WithAutoSplitOnOversize(), watcher.WithErrorHandler(func(err error) {
+		mu.Lock()
+		warnings = append(warnings, err)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"app/db/host", "app/db/port", "app/name"}
+	if got := treeKeys(<-pairs); !equalStrings(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	kv.Put("app/db/user", []byte("admin"))
+	want = []string{"app/db/host", "app/db/port", "app/db/user", "app/name"}
+	if got := treeKeys(<-pairs); !equalStrings(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(warnings) != 1 || !errors.Is(warnings[0], watcher.ErrResponseTooLarge) {
+		t.Fatalf("got warnings %v, want a single ErrResponseTooLarge", warnings)
+	}
+}
+
+func TestAutoSplitRetriesWholeTree(t *testing.T) {
+	checkGoroutines(t)
+	defer watcher.SetSplitRetryInterval(20 * time.Millisecond)()
+	kv := newOversizedKV()
+
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchTree(ctx, "app/", watcher.WithAutoSplitOnOversize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+
+	kv.setOversized(false)
+	time.Sleep(30 * time.Millisecond)
+	kv.Put("app/db/user", []byte("admin"))
+	<-pairs
+	if kv.wholeLists() == 0 {
+		t.Fatal("split watch didn't list the whole tree again after the retry interval")
+	}
+
+	// unsplit, the watch blocks on the whole tree again
+	lists := kv.wholeLists()
+	kv.Put("app/db/user", []byte("root"))
+	<-pairs
+	if kv.wholeLists() <= lists {
+		t.Fatal("watch didn't stay unsplit")
+	}
+}
+
+func TestOversizedMatchesOnlyStatus(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.SetError(errors.New("value too large for the validator"))
+
+	errs := make(chan error, 10)
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	pairs, err := w.WatchTree(context.Background(), "app/", watcher.WithAutoSplitOnOversize(),
+		watcher.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range pairs {
+	}
+
+	if err := <-errs; errors.Is(err, watcher.ErrResponseTooLarge) {
+		t.Fatalf("got %v, want an error that isn't ErrResponseTooLarge", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ErrStaleFollower is reported to the error handler if the staleness guard detected a query result
+// from a server that had no contact to the leader for longer than the configured threshold
+var ErrStaleFollower = errors.New("stale follower")
+
+// StalenessAction is how a watch escapes a follower that serves stale results
+type StalenessAction int
+
+const (
+	// StalenessConsistentRead re-issues the query as a consistent read served by the leader
+	StalenessConsistentRead StalenessAction = iota + 1
+	// StalenessReset resets the wait index and re-issues the query bypassing the agent cache,
+	// so the agent can forward it to another server
+	StalenessReset
+)
+
+// WithStalenessGuard protects a watch against a partitioned follower. With stale reads a follower that lost
+// contact to the leader can keep answering with an old index, so the blocking query never wakes up even though
+// the leader has newer data. If the LastContact of a result exceeds maxLastContact the result is discarded,
+// ErrStaleFollower is passed to the error handler and the query is re-issued according to action.
+// The next query uses the default stale read again.
+func WithStalenessGuard(maxLastContact time.Duration, action StalenessAction) WatchOption {
+	return func(o *watchOptions) {
+		o.maxLastContact = maxLastContact
+		o.stalenessAction = action
+	}
+}
+
+// WithMaxStale bounds the staleness of the stale reads of a watch to maxStale. Consul agents accept a max_stale
+// query parameter for this, but the QueryOptions of the consul/api package have no field for it, so the watch
+// applies the bound itself: every query stays a stale read and the LastContact of its result, the
+// X-Consul-LastContact header, is compared with maxStale. A result from a server whose contact to the leader is
+// older is discarded and the query is re-issued once as a consistent read served by the leader, bypassing the agent
+// cache, like WithStalenessGuard with StalenessConsistentRead. The query after it is a stale read again. A zero
+// maxStale disables the bound.
+func WithMaxStale(maxStale time.Duration) WatchOption {
+	return WithStalenessGuard(maxStale, StalenessConsistentRead)
+}
+
+// escapeStale checks meta of a successful query against the staleness guard. If the last contact exceeds the
+// threshold it reports ErrStaleFollower, changes opts according to the action and returns true, the result
+// should be discarded then.
+func (o *watchOptions) escapeStale(opts *consul.QueryOptions, meta *consul.QueryMeta) bool {
+	if o.maxLastContact <= 0 || meta.LastContact <= o.maxLastContact {
+		return false
+	}
+
+	o.handleError(fmt.Errorf("%w: last contact to the leader %s ago exceeds %s",
+		ErrStaleFollower, meta.LastContact.Round(time.Millisecond), o.maxLastContact))
+
+	opts.UseCache = false
+	switch o.stalenessAction {
+	case StalenessConsistentRead:
+		opts.AllowStale = false
+		opts.RequireConsistent = true
+	default:
+		opts.WaitIndex = 0
+		opts.WaitHash = ""
+	}
+
+	return true
+}
+
+// restoreStale switches opts back to the default stale read after escaping a stale follower
+func restoreStale(opts *consul.QueryOptions) {
+	opts.AllowStale = true
+	opts.RequireConsistent = false
+	opts.UseCache = true
+}
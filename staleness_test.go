@@ -0,0 +1,125 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// laggingKV is a partitioned follower that keeps answering with pair and a high LastContact,
+// queries for which fresh returns true reach a server with the current state of the KV
+type laggingKV struct {
+	*watchertest.KV
+	pair  *consul.KVPair
+	fresh func(q *consul.QueryOptions) bool
+}
+
+func (kv *laggingKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if kv.fresh(q) {
+		return kv.KV.Get(key, q)
+	}
+	if q.WaitIndex >= kv.pair.ModifyIndex {
+		<-q.Context().Done()
+		return nil, nil, q.Context().Err()
+	}
+
+	return kv.pair, &consul.QueryMeta{LastIndex: kv.pair.ModifyIndex, LastContact: time.Minute, KnownLeader: true}, nil
+}
+
+func TestStalenessGuard(t *testing.T) {
+	tests := []struct {
+		name   string
+		action watcher.StalenessAction
+		fresh  func(q *consul.QueryOptions) bool
+	}{
+		{
+			name:   "consistent read",
+			action: watcher.StalenessConsistentRead,
+			fresh:  func(q *consul.QueryOptions) bool { return q.RequireConsistent },
+		},
+		{
+			name:   "reset",
+			action: watcher.StalenessReset,
+			fresh:  func(q *consul.QueryOptions) bool { return !q.UseCache && q.WaitIndex == 0 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			fake := watchertest.NewKV()
+			fake.Put("key", []byte("old"))
+			old, _, err := fake.Get("key", &consul.QueryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake.Put("key", []byte("new"))
+			kv := &laggingKV{KV: fake, pair: old, fresh: tt.fresh}
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			errs := make(chan error, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", watcher.WithStalenessGuard(time.Second, tt.action),
+				watcher.WithErrorHandler(func(err error) {
+					select {
+					case errs <- err:
+					default:
+					}
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the stale result is discarded and read again from a server in contact with the leader
+			if pair := <-pairs; string(pair.Value) != "new" {
+				t.Fatalf("got %s, want new", pair.Value)
+			}
+			if err := <-errs; !errors.Is(err, watcher.ErrStaleFollower) {
+				t.Fatalf("got %v, want ErrStaleFollower", err)
+			}
+		})
+	}
+}
+
+func TestMaxStale(t *testing.T) {
+	checkGoroutines(t)
+	fake := watchertest.NewKV()
+	fake.Put("key", []byte("old"))
+	old, _, err := fake.Get("key", &consul.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Put("key", []byte("new"))
+
+	queries := make(chan consul.QueryOptions, 10)
+	kv := &laggingKV{KV: fake, pair: old, fresh: func(q *consul.QueryOptions) bool {
+		queries <- *q
+		return q.RequireConsistent
+	}}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key", watcher.WithMaxStale(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; string(pair.Value) != "new" {
+		t.Fatalf("got %s, want new", pair.Value)
+	}
+
+	// the stale read exceeding the bound is read again from the leader, later reads are stale again
+	for i, want := range []bool{false, true, false} {
+		q := <-queries
+		if q.RequireConsistent != want || q.AllowStale == want {
+			t.Fatalf("got consistent %v stale %v for query %d, want consistent %v",
+				q.RequireConsistent, q.AllowStale, i, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// durationType is the type of time.Duration, durations are parsed from their string representation
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// WatchStruct watches all keys below prefix and emits them mapped onto the fields of a struct of type T.
+// Fields are mapped by a consul tag holding the key relative to prefix, e.g. `consul:"database/host"`.
+// Every emission starts with a deep copy of defaults, so fields whose key is missing keep their default value
+// and maps, slices and pointers of defaults are never shared between emissions. Unexported fields are copied
+// shallowly and defaults must not contain cycles.
+// Strings, byte slices, bools, numbers and durations are parsed from the raw value, other field types are
+// decoded as JSON. A value that can't be converted is sent to the error channel and the snapshot is skipped
+// without ending the watch. Changes to keys that aren't mapped by T don't emit.
+// Both channels must be drained, they are closed when the watch ends.
+func WatchStruct[T any](
+	ctx context.Context, w *Watcher, prefix string, defaults T, opts ...WatchOption,
+) (<-chan T, <-chan error, error) {
+	typ := reflect.TypeOf(defaults)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("%w: WatchStruct needs a struct type, got %v", ErrInvalidOptions, typ)
+	}
+
+	fields := make(map[string]int)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if key := field.Tag.Get("consul"); key != "" && field.IsExported() {
+			fields[strings.Trim(key, "/")] = i
+		}
+	}
+
+	pairs, err := w.WatchTree(ctx, prefix, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan T)
+	errs := make(chan error)
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		full := w.emittedKey(prefix)
+		var lastHash string
+		for tree := range pairs {
+			mapped := make(consul.KVPairs, 0, len(fields))
+			values := make(map[int]*consul.KVPair, len(fields))
+			for _, pair := range tree {
+				name := strings.Trim(strings.TrimPrefix(pair.Key, full), "/")
+				if i, ok := fields[name]; ok {
+					mapped = append(mapped, pair)
+					values[i] = pair
+				}
+			}
+
+			hash := treeHash(mapped)
+			if hash == lastHash {
+				continue
+			}
+			lastHash = hash
+
+			target := copyValue(reflect.ValueOf(defaults)).Interface().(T)
+			v := reflect.ValueOf(&target).Elem()
+			var convErr error
+			for i, pair := range values {
+				if err := setField(v.Field(i), pair.Value); err != nil {
+					convErr = fmt.Errorf("convert %s into %s: %w", pair.Key, typ.Field(i).Name, err)
+					break
+				}
+			}
+			if convErr != nil {
+				select {
+				case errs <- convErr:
+				case <-ctx.Done():
+				}
+				continue
+			}
+
+			select {
+			case out <- target:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, errs, nil
+}
+
+// setField parses raw into field according to the type of the field
+func setField(field reflect.Value, raw []byte) error {
+	s := strings.TrimSpace(string(raw))
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8:
+		field.SetBytes(append([]byte(nil), raw...))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(string(raw))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+			return err
+		}
+		field.Set(ptr.Elem())
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+type structConfig struct {
+	Host   string            `consul:"host"`
+	Port   int               `consul:"port"`
+	Labels map[string]string `consul:"labels"`
+	Tags   []string          `consul:"tags"`
+}
+
+func TestWatchStructDefaultsNotShared(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/host", []byte("localhost"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	defaults := structConfig{Port: 80, Labels: map[string]string{"env": "dev"}, Tags: []string{"a"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	configs, errs, err := watcher.WatchStruct(ctx, w, "app/", defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for err := range errs {
+			t.Error(err)
+		}
+	}()
+
+	config := <-configs
+	if config.Host != "localhost" || config.Port != 80 {
+		t.Fatalf("got %+v, want host localhost and port 80", config)
+	}
+	config.Labels["env"] = "changed"
+	config.Tags[0] = "changed"
+
+	kv.Put("app/port", []byte("8080"))
+	config = <-configs
+	if config.Port != 8080 || config.Labels["env"] != "dev" || config.Tags[0] != "a" {
+		t.Fatalf("got %+v, want port 8080 with the unchanged defaults", config)
+	}
+	if defaults.Labels["env"] != "dev" || defaults.Tags[0] != "a" {
+		t.Fatalf("defaults were modified: %+v", defaults)
+	}
+}
@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchSummary summarizes a watch after it ended
+type WatchSummary struct {
+	// Emissions is the number of values emitted
+	Emissions uint64
+	// Retries is the number of queries retried after an error
+	Retries uint64
+	// Uptime is the time the watch was running
+	Uptime time.Duration
+	// LastSuccessfulPoll is when the last successful query returned, see WatchInfo.LastSuccessfulPoll
+	LastSuccessfulPoll time.Time
+	// Err is the terminal reason of the watch, nil if it was closed or its context cancelled
+	Err error
+}
+
+// Subscription is a handle to a running watch
+type Subscription[T any] struct {
+	updates <-chan T
+	cancel  context.CancelFunc
+	done    <-chan struct{}
+	state   *watchState
+	err     func() error
+	// consistentNext is the flag of the watch that makes its next query consistent if set to 1
+	consistentNext *int32
+	resetBackoff   chan<- struct{}
+}
+
+// newSubscription returns a Subscription for the watch r
+func newSubscription[T, E any](r *run[T, E]) *Subscription[E] {
+	return &Subscription[E]{
+		updates:        r.out,
+		cancel:         r.cancel,
+		done:           r.done,
+		state:          r.state,
+		err:            r.err,
+		consistentNext: &r.consistentNext,
+		resetBackoff:   r.resetBackoff,
+	}
+}
+
+// ForceConsistentNext makes the next query of the watch a consistent read served by the leader, bypassing
+// stale reads and the agent cache, e.g. to read your own write. Later queries use the configured mode again.
+// A blocking query that is already running is not interrupted, the next query starts once it returned.
+func (s *Subscription[T]) ForceConsistentNext() {
+	atomic.StoreInt32(s.consistentNext, 1)
+}
+
+// ResetBackoff resets the backoff of the watch, e.g. when an external signal reports that Consul recovered.
+// If the watch is waiting to retry a failed query it retries at once, if a query is running a retry after it
+// fails doesn't wait. It has no effect on a healthy watch and is safe to call concurrently.
+func (s *Subscription[T]) ResetBackoff() {
+	select {
+	case s.resetBackoff <- struct{}{}:
+	default:
+	}
+}
+
+// Updates returns the channel the watch emits to, it is closed when the watch ends
+func (s *Subscription[T]) Updates() <-chan T {
+	return s.updates
+}
+
+// Err returns the terminal error of the watch once it ended: the error it died with
+// or context.DeadlineExceeded, nil if it was closed or cancelled or is still running
+func (s *Subscription[T]) Err() error {
+	select {
+	case <-s.done:
+		return s.err()
+	default:
+		return nil
+	}
+}
+
+// Summary returns the summary of the watch so far, populated from the same counters as Watcher.Stats
+func (s *Subscription[T]) Summary() WatchSummary {
+	summary := s.state.summary()
+	summary.Err = s.Err()
+	return summary
+}
+
+// ExportIndex returns the Consul index of the last emitted value, 0 if nothing was emitted yet.
+// A new watch started with WithStartIndex continues from it, values observed but not yet emitted
+// because of the debounce are read again by the new watch.
+func (s *Subscription[T]) ExportIndex() uint64 {
+	return s.state.index()
+}
+
+// Done returns a channel that is closed once the watch ended completely: the query loop stopped,
+// the channel of Updates is closed and no goroutine of the watch is running anymore. It can be used
+// to wait for the watch before closing the Consul client or exiting.
+func (s *Subscription[T]) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops the watch, waits until it ended and returns its final summary. Values the watch
+// still sends after Close was called are discarded, like a pending value with WithCloseDrain.
+func (s *Subscription[T]) Close() WatchSummary {
+	s.cancel()
+	for range s.updates {
+	}
+	<-s.done
+	return s.Summary()
+}
+
+// SubscribeKey works like WatchKey but returns a Subscription to control the watch
+func (w *Watcher) SubscribeKey(
+	ctx context.Context, key string, opts ...WatchOption,
+) (*Subscription[*consul.KVPair], error) {
+	o := w.newWatchOptions(opts)
+	r, err := start(ctx, w, o, w.keySource(key, o), stateless(valueOnly[*consul.KVPair]))
+	if err != nil {
+		return nil, err
+	}
+
+	return newSubscription(r), nil
+}
+
+// SubscribeTree works like WatchTree but returns a Subscription to control the watch
+func (w *Watcher) SubscribeTree(
+	ctx context.Context, path string, opts ...WatchOption,
+) (*Subscription[consul.KVPairs], error) {
+	o := w.newWatchOptions(opts)
+	r, err := start(ctx, w, o, w.treeSource(path, o), stateless(valueOnly[consul.KVPairs]))
+	if err != nil {
+		return nil, err
+	}
+
+	return newSubscription(r), nil
+}
@@ -0,0 +1,183 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// failingKV fails the next queries with a retryable error while failures is positive
+type failingKV struct {
+	*watchertest.KV
+	failures int32
+}
+
+func (kv *failingKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if atomic.AddInt32(&kv.failures, -1) >= 0 {
+		return nil, nil, errors.New("Unexpected response code: 500 (No cluster leader)")
+	}
+	return kv.KV.Get(key, q)
+}
+
+func TestSubscriptionSummary(t *testing.T) {
+	checkGoroutines(t)
+	kv := &failingKV{KV: watchertest.NewKV()}
+	kv.Put("key", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	sub, err := w.SubscribeKey(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Updates()
+
+	// the next two queries fail and are retried, depending on whether the blocking query was already sent
+	// these are the queries before or after the update, the third value is only emitted after both of them
+	atomic.StoreInt32(&kv.failures, 2)
+	kv.Put("key", []byte("2"))
+	<-sub.Updates()
+	kv.Put("key", []byte("3"))
+	<-sub.Updates()
+
+	summary := sub.Close()
+	if summary.Emissions != 3 || summary.Retries != 2 {
+		t.Fatalf("got %d emissions and %d retries, want 3 and 2", summary.Emissions, summary.Retries)
+	}
+	if summary.Err != nil || summary.Uptime <= 0 || summary.LastSuccessfulPoll.IsZero() {
+		t.Fatalf("got err %v uptime %s last poll %s, want a cancelled watch with an uptime and a poll",
+			summary.Err, summary.Uptime, summary.LastSuccessfulPoll)
+	}
+}
+
+func TestSubscriptionIndexHandoff(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	old, err := w.SubscribeKey(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-old.Updates()
+	index := old.ExportIndex()
+	old.Close()
+	if index == 0 {
+		t.Fatal("got index 0 after an emission")
+	}
+
+	// the resumed watch doesn't emit the unchanged value again
+	resumed, err := w.SubscribeKey(context.Background(), "key", watcher.WithStartIndex(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	select {
+	case pair := <-resumed.Updates():
+		t.Fatalf("got spurious emission %s after resuming", pair.Value)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("key", []byte("2"))
+	if pair := <-resumed.Updates(); string(pair.Value) != "2" {
+		t.Fatalf("got %s, want 2", pair.Value)
+	}
+}
+
+// consistencyKV records whether every query is a consistent read
+type consistencyKV struct {
+	*watchertest.KV
+	consistent chan bool
+}
+
+func (kv *consistencyKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	kv.consistent <- q.RequireConsistent && !q.AllowStale && !q.UseCache
+	return kv.KV.Get(key, q)
+}
+
+func TestSubscriptionForceConsistentNext(t *testing.T) {
+	checkGoroutines(t)
+	kv := &consistencyKV{KV: watchertest.NewKV(), consistent: make(chan bool, 10)}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	sub, err := w.SubscribeKey(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	<-sub.Updates()
+	<-kv.consistent
+	// the blocking query is running when the consistent read is requested
+	<-kv.consistent
+
+	sub.ForceConsistentNext()
+	for i, want := range []bool{true, false} {
+		kv.Put("key", []byte(fmt.Sprint(i)))
+		<-sub.Updates()
+		if got := <-kv.consistent; got != want {
+			t.Fatalf("got consistent %v for query %d after the request, want %v", got, i, want)
+		}
+	}
+}
+
+func TestSubscriptionMaxEmissions(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	sub, err := w.SubscribeKey(context.Background(), "key", watcher.WithMaxEmissions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the watch closes cleanly after the third value
+	var got []string
+	for pair := range sub.Updates() {
+		got = append(got, string(pair.Value))
+		kv.Put("key", []byte(fmt.Sprint(len(got))))
+	}
+	<-sub.Done()
+	if !equalStrings(got, []string{"0", "1", "2"}) {
+		t.Fatalf("got %v, want the first 3 values", got)
+	}
+	if summary := sub.Summary(); summary.Err != nil || summary.Emissions != 3 {
+		t.Fatalf("got err %v and %d emissions, want a clean end after 3", summary.Err, summary.Emissions)
+	}
+}
+
+func TestSubscriptionResetBackoff(t *testing.T) {
+	checkGoroutines(t)
+	kv := &failingKV{KV: watchertest.NewKV(), failures: 1}
+	kv.Put("key", []byte("value"))
+	// the first retry would wait for an hour
+	w := watcher.New(nil, time.Hour, 0, watcher.WithKVClient(kv))
+
+	errs := make(chan error, 10)
+	sub, err := w.SubscribeKey(context.Background(), "key", watcher.WithErrorHandler(func(err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	<-errs
+
+	// the reset during the backoff retries at once
+	sub.ResetBackoff()
+	select {
+	case pair := <-sub.Updates():
+		if string(pair.Value) != "value" {
+			t.Fatalf("got %s, want value", pair.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("got no retry after the reset")
+	}
+}
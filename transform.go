@@ -0,0 +1,47 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchKeyTransform watches for changes to a key and emits the result of fn for every emitted key value pair,
+// so decoders, validators and projections can be built as one stage. fn is called on the watch goroutine after
+// debouncing and deduplication, also with nil for a missing or deleted key. An error of fn is sent to the error
+// channel without ending the watch and nothing is emitted for that pair.
+// Both channels must be drained, they are closed when the watch ends.
+func (w *Watcher) WatchKeyTransform(
+	ctx context.Context, key string, fn func(*consul.KVPair) (interface{}, error), opts ...WatchOption,
+) (<-chan interface{}, <-chan error, error) {
+	pairs, err := w.WatchKey(ctx, key, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan interface{})
+	errs := make(chan error)
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		for pair := range pairs {
+			value, err := fn(pair)
+			if err != nil {
+				select {
+				case errs <- fmt.Errorf("transform %s: %w", key, err):
+				case <-ctx.Done():
+				}
+				continue
+			}
+
+			select {
+			case out <- value:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, errs, nil
+}
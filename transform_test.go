@@ -0,0 +1,60 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchKeyTransform(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("replicas", []byte("3"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	errMissing := errors.New("missing")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs, err := w.WatchKeyTransform(ctx, "replicas", func(pair *consul.KVPair) (interface{}, error) {
+		if pair == nil {
+			return nil, errMissing
+		}
+		return strconv.Atoi(string(pair.Value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		for range values {
+		}
+		for range errs {
+		}
+	}()
+
+	if value := <-values; value != 3 {
+		t.Fatalf("got %v, want 3", value)
+	}
+
+	// failing inputs are reported without ending the watch
+	kv.Put("replicas", []byte("many"))
+	var numErr *strconv.NumError
+	if err := <-errs; !errors.As(err, &numErr) {
+		t.Fatalf("got %v, want a parse error", err)
+	}
+	kv.Delete("replicas")
+	if err := <-errs; !errors.Is(err, errMissing) {
+		t.Fatalf("got %v, want the error for the missing key", err)
+	}
+
+	kv.Put("replicas", []byte("5"))
+	if value := <-values; value != 5 {
+		t.Fatalf("got %v, want 5", value)
+	}
+}
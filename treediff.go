@@ -0,0 +1,120 @@
+package watcher
+
+import (
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WithDeleteGrace makes the diff based tree watches WatchTreeChanges, WatchTreeApply, WatchTreePatch,
+// WatchTreeDeltas and WatchTreePerKey report a key as deleted only after it was missing for grace. A key that
+// reappears with the same ModifyIndex within grace causes no change at all, so a follower that briefly serves
+// an inconsistent snapshot with stale reads doesn't cause spurious deletions. Deletions are reported once grace
+// elapsed even if no new snapshot arrived.
+func WithDeleteGrace(grace time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.deleteGrace = grace
+	}
+}
+
+// diffSnapshots calls changed with the differences between consecutive snapshots until snapshots is closed.
+// Deletions are held back for grace, changed is only called if something changed.
+func diffSnapshots(
+	snapshots <-chan consul.KVPairs, grace time.Duration, changed func(created, updated, deleted consul.KVPairs),
+) {
+	d := treeDiff{grace: grace, missing: make(map[string]time.Time)}
+	defer d.stop()
+
+	for {
+		var created, updated, deleted consul.KVPairs
+		select {
+		case pairs, ok := <-snapshots:
+			if !ok {
+				return
+			}
+			created, updated, deleted = d.next(pairs, time.Now())
+		case <-d.expired():
+			created, updated, deleted = d.next(d.latest, time.Now())
+		}
+
+		if len(created) > 0 || len(updated) > 0 || len(deleted) > 0 {
+			changed(created, updated, deleted)
+		}
+	}
+}
+
+// treeDiff computes the changes between snapshots of a tree holding back deletions for a grace period
+type treeDiff struct {
+	grace time.Duration
+	// view is the last reported state, it includes missing keys whose grace didn't elapse
+	view consul.KVPairs
+	// latest is the last snapshot
+	latest consul.KVPairs
+	// missing are the keys of view that are missing in latest and when they went missing
+	missing map[string]time.Time
+	timer   *time.Timer
+}
+
+// next returns the changes from the last reported state to pairs at now
+func (d *treeDiff) next(pairs consul.KVPairs, now time.Time) (created, updated, deleted consul.KVPairs) {
+	d.latest = pairs
+	view := pairs
+	if d.grace > 0 {
+		present := make(map[string]struct{}, len(pairs))
+		for _, pair := range pairs {
+			present[pair.Key] = struct{}{}
+		}
+		for key := range d.missing {
+			if _, ok := present[key]; ok {
+				delete(d.missing, key)
+			}
+		}
+
+		view = append(consul.KVPairs(nil), pairs...)
+		for _, pair := range d.view {
+			if _, ok := present[pair.Key]; ok {
+				continue
+			}
+
+			since, ok := d.missing[pair.Key]
+			if !ok {
+				since = now
+				d.missing[pair.Key] = now
+			}
+			if now.Sub(since) < d.grace {
+				view = append(view, pair)
+			} else {
+				delete(d.missing, pair.Key)
+			}
+		}
+	}
+
+	created, updated, deleted = DiffKVPairs(d.view, view)
+	d.view = view
+	return created, updated, deleted
+}
+
+// expired returns a channel that fires when the grace of the next missing key elapses, nil without missing keys
+func (d *treeDiff) expired() <-chan time.Time {
+	d.stop()
+	if len(d.missing) == 0 {
+		return nil
+	}
+
+	var first time.Time
+	for _, since := range d.missing {
+		if first.IsZero() || since.Before(first) {
+			first = since
+		}
+	}
+	d.timer = time.NewTimer(time.Until(first.Add(d.grace)))
+	return d.timer.C
+}
+
+// stop stops the expiry timer
+func (d *treeDiff) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
@@ -0,0 +1,104 @@
+package watcher
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// TreeDelta are the keys of a tree that changed with one debounced update, see WatchTreeDeltas
+type TreeDelta struct {
+	Created consul.KVPairs
+	Updated consul.KVPairs
+	// Deleted are the last known pairs of the deleted keys
+	Deleted consul.KVPairs
+}
+
+// TreeState is the current state of a tree maintained by WatchTreeDeltas. It is safe for concurrent use.
+// The state is updated before the delta of an update is emitted and can already contain the next update
+// while the consumer still processes a delta. The returned pairs are shared and must not be modified.
+type TreeState struct {
+	mu    sync.RWMutex
+	pairs map[string]*consul.KVPair
+}
+
+// Get returns the pair of key, false if it doesn't exist
+func (s *TreeState) Get(key string) (*consul.KVPair, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pair, ok := s.pairs[key]
+	return pair, ok
+}
+
+// Len returns the number of keys
+func (s *TreeState) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.pairs)
+}
+
+// Snapshot returns all pairs sorted by key
+func (s *TreeState) Snapshot() consul.KVPairs {
+	s.mu.RLock()
+	pairs := make(consul.KVPairs, 0, len(s.pairs))
+	for _, pair := range s.pairs {
+		pairs = append(pairs, pair)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return pairs
+}
+
+// apply updates the state with delta
+func (s *TreeState) apply(delta TreeDelta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pair := range delta.Deleted {
+		delete(s.pairs, pair.Key)
+	}
+	for _, pairs := range []consul.KVPairs{delta.Created, delta.Updated} {
+		for _, pair := range pairs {
+			s.pairs[pair.Key] = pair
+		}
+	}
+}
+
+// WatchTreeDeltas watches for changes to a directory and emits only the keys that changed with every debounced
+// update, the full state is maintained in the returned TreeState. For large trees this avoids handing a copy
+// of all keys to the consumer for every change. The first delta contains all existing keys as created.
+// Deletions are held back with WithDeleteGrace like with WatchTreeChanges.
+func (w *Watcher) WatchTreeDeltas(
+	ctx context.Context, path string, opts ...WatchOption,
+) (*TreeState, <-chan TreeDelta, error) {
+	o := w.newWatchOptions(opts)
+	snapshots, err := startWatch(ctx, w, o, w.treeSource(path, o), valueOnly[consul.KVPairs])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := &TreeState{pairs: make(map[string]*consul.KVPair)}
+	out := make(chan TreeDelta)
+	go func() {
+		defer close(out)
+
+		diffSnapshots(snapshots, o.deleteGrace, func(created, updated, deleted consul.KVPairs) {
+			delta := TreeDelta{Created: created, Updated: updated, Deleted: deleted}
+			state.apply(delta)
+
+			select {
+			case out <- delta:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return state, out, nil
+}
@@ -0,0 +1,95 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchTreeDeltas(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("2"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	state, deltas, err := w.WatchTreeDeltas(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delta := <-deltas
+	if got := treeKeys(delta.Created); !equalStrings(got, []string{"app/a", "app/b"}) {
+		t.Fatalf("got created %v, want app/a and app/b", got)
+	}
+
+	kv.Put("app/a", []byte("3"))
+	kv.Delete("app/b")
+	var updated, deleted []string
+	for len(updated) == 0 || len(deleted) == 0 {
+		delta = <-deltas
+		updated = append(updated, treeKeys(delta.Updated)...)
+		deleted = append(deleted, treeKeys(delta.Deleted)...)
+	}
+	if !equalStrings(updated, []string{"app/a"}) || !equalStrings(deleted, []string{"app/b"}) {
+		t.Fatalf("got updated %v and deleted %v, want app/a and app/b", updated, deleted)
+	}
+
+	if pair, ok := state.Get("app/a"); !ok || string(pair.Value) != "3" {
+		t.Fatalf("got state of app/a %v, want 3", pair)
+	}
+	if _, ok := state.Get("app/b"); ok || state.Len() != 1 {
+		t.Fatalf("got %v, want only app/a", treeKeys(state.Snapshot()))
+	}
+}
+
+// benchmarkTree runs b.N single key updates of a tree of 10000 keys, receive reads the emission of an update
+func benchmarkTree(b *testing.B, start func(ctx context.Context, w *watcher.Watcher) (receive func(), err error)) {
+	kv := watchertest.NewKV()
+	for i := 0; i < 10000; i++ {
+		kv.Put(fmt.Sprintf("app/%05d", i), make([]byte, 256))
+	}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receive, err := start(ctx, w)
+	if err != nil {
+		b.Fatal(err)
+	}
+	receive()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Put(fmt.Sprintf("app/%05d", i%10000), []byte(fmt.Sprint(i)))
+		receive()
+	}
+}
+
+func BenchmarkWatchTreeDeltas(b *testing.B) {
+	benchmarkTree(b, func(ctx context.Context, w *watcher.Watcher) (func(), error) {
+		_, deltas, err := w.WatchTreeDeltas(ctx, "app/")
+		return func() { <-deltas }, err
+	})
+}
+
+func BenchmarkWatchTreeChanges(b *testing.B) {
+	benchmarkTree(b, func(ctx context.Context, w *watcher.Watcher) (func(), error) {
+		changes, err := w.WatchTreeChanges(ctx, "app/")
+		return func() { <-changes }, err
+	})
+}
+
+func BenchmarkWatchTree(b *testing.B) {
+	benchmarkTree(b, func(ctx context.Context, w *watcher.Watcher) (func(), error) {
+		trees, err := w.WatchTree(ctx, "app/")
+		return func() { <-trees }, err
+	})
+}
@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WatchTxn watches the result of a read-only transaction, so several related keys are always read as a
+// consistent set. Transactions don't support blocking queries, the watch blocks on the keys below the longest
+// common prefix of all keys in ops or the prefix set with WithCoveringPrefix instead and executes the
+// transaction after every change. A result with the same keys and indexes as the previous one is not emitted.
+// ops may only contain non-nil ops with read verbs like get, get-tree and the checks, otherwise ErrInvalidOptions
+// is returned.
+// A failed transaction, e.g. a check that doesn't hold, ends the watch like other non-retryable errors.
+func (w *Watcher) WatchTxn(
+	ctx context.Context, ops consul.KVTxnOps, opts ...WatchOption,
+) (<-chan consul.KVTxnResponse, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: empty transaction", ErrInvalidOptions)
+	}
+
+	txn := make(consul.KVTxnOps, len(ops))
+	for i, op := range ops {
+		if op == nil {
+			return nil, fmt.Errorf("%w: transaction op %d is nil", ErrInvalidOptions, i)
+		}
+		switch op.Verb {
+		case consul.KVGet, consul.KVGetTree, consul.KVCheckIndex, consul.KVCheckSession, consul.KVCheckNotExists:
+		default:
+			return nil, fmt.Errorf("%w: transaction verb %q is not read-only", ErrInvalidOptions, op.Verb)
+		}
+
+		c := *op
+		c.Key = w.fullKey(op.Key)
+		txn[i] = &c
+	}
+
+	o := w.newWatchOptions(opts)
+	prefix := txn[0].Key
+	for _, op := range txn[1:] {
+		prefix = commonPrefix(prefix, op.Key)
+	}
+	if o.coveringPrefix != "" {
+		prefix = w.fullKey(o.coveringPrefix)
+		for _, op := range txn {
+			if !strings.HasPrefix(op.Key, prefix) {
+				return nil, fmt.Errorf("%w: key %s is not below the covering prefix %s", ErrInvalidOptions, op.Key, prefix)
+			}
+		}
+	}
+
+	return startWatch(ctx, w, o, w.txnSource(txn, prefix), valueOnly[consul.KVTxnResponse])
+}
+
+// WithCoveringPrefix sets the prefix WatchTxn blocks on instead of the longest common prefix of the keys in the
+// transaction. A narrower prefix avoids wake ups for unrelated keys, a broader one can be necessary if the keys have
+// no useful common prefix. Starting the watch fails with ErrInvalidOptions if a key of the transaction is not
+// below prefix.
+func WithCoveringPrefix(prefix string) WatchOption {
+	return func(o *watchOptions) {
+		o.coveringPrefix = prefix
+	}
+}
+
+// txnSource returns the source for watching the result of a read-only transaction blocking on the keys below prefix
+func (w *Watcher) txnSource(ops consul.KVTxnOps, prefix string) source[consul.KVTxnResponse] {
+	kv := w.kv
+
+	src := source[consul.KVTxnResponse]{
+		kind:   KindTxn,
+		target: prefix,
+		fetch: func(opts *consul.QueryOptions) (consul.KVTxnResponse, *consul.QueryMeta, error) {
+			// only the keys are listed to wait for a change, the values are read by the transaction
+			_, meta, err := kv.Keys(prefix, "", opts)
+			if err != nil {
+				return consul.KVTxnResponse{}, nil, err
+			}
+
+			// the transaction reads with the same consistency, token and mutated options as the blocking query,
+			// only the blocking doesn't apply to it
+			txnOpts := *opts
+			txnOpts.WaitIndex = 0
+			txnOpts.WaitHash = ""
+			txnOpts.WaitTime = 0
+			ok, resp, _, err := kv.Txn(ops, &txnOpts)
+			if err != nil {
+				return consul.KVTxnResponse{}, nil, err
+			}
+			if !ok {
+				errs := make([]string, 0, len(resp.Errors))
+				for _, txnErr := range resp.Errors {
+					errs = append(errs, fmt.Sprintf("op %d: %s", txnErr.OpIndex, txnErr.What))
+				}
+				return consul.KVTxnResponse{}, nil, fmt.Errorf("transaction failed: %s", strings.Join(errs, ", "))
+			}
+
+			resp.Results = w.trimPairs(resp.Results)
+			return *resp, meta, nil
+		},
+		identity: func(resp consul.KVTxnResponse) string {
+			return treeHash(resp.Results)
+		},
+	}
+	if kv == nil {
+		src.err = missingClient("KV")
+	}
+
+	return src
+}
+
+// commonPrefix returns the longest common prefix of a and b
+func commonPrefix(a, b string) string {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:n]
+}
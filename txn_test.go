@@ -0,0 +1,165 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchTxnInvalidOps(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  consul.KVTxnOps
+	}{
+		{name: "empty", ops: consul.KVTxnOps{}},
+		{name: "nil op", ops: consul.KVTxnOps{{Verb: consul.KVGet, Key: "app/a"}, nil}},
+		{name: "write", ops: consul.KVTxnOps{{Verb: consul.KVSet, Key: "app/a"}}},
+	}
+
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := w.WatchTxn(context.Background(), tt.ops); !errors.Is(err, watcher.ErrInvalidOptions) {
+				t.Fatalf("got %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+}
+
+func TestWatchTxn(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("1"))
+	// the debounce merges the writes of both keys like a single transaction
+	w := watcher.New(nil, 10*time.Millisecond, 50*time.Millisecond, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := w.WatchTxn(ctx, consul.KVTxnOps{
+		{Verb: consul.KVGet, Key: "app/a"},
+		{Verb: consul.KVGet, Key: "app/b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := func(resp consul.KVTxnResponse) []string {
+		var values []string
+		for _, pair := range resp.Results {
+			values = append(values, pair.Key+"="+string(pair.Value))
+		}
+		return values
+	}
+	if got := values(<-results); !equalStrings(got, []string{"app/a=1", "app/b=1"}) {
+		t.Fatalf("got %v, want both keys at 1", got)
+	}
+
+	kv.Put("app/a", []byte("2"))
+	kv.Put("app/b", []byte("2"))
+	if got := values(<-results); !equalStrings(got, []string{"app/a=2", "app/b=2"}) {
+		t.Fatalf("got %v, want both keys at 2", got)
+	}
+
+	// a write outside the transaction doesn't emit
+	kv.Put("app/c", []byte("1"))
+	select {
+	case resp := <-results:
+		t.Fatalf("got %v for a write outside the transaction", values(resp))
+	case <-time.After(80 * time.Millisecond):
+	}
+}
+
+func TestWatchTxnCoveringPrefix(t *testing.T) {
+	ops := consul.KVTxnOps{
+		{Verb: consul.KVGet, Key: "app/db/host"},
+		{Verb: consul.KVGet, Key: "app/db/port"},
+	}
+	tests := []struct {
+		name string
+		opts []watcher.WatchOption
+		want string
+	}{
+		{name: "auto", want: "app/db/"},
+		{name: "explicit", opts: []watcher.WatchOption{watcher.WithCoveringPrefix("app/")}, want: "app/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if _, err := w.WatchTxn(ctx, ops, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if watches := w.List(); len(watches) != 1 || watches[0].Target != tt.want {
+				t.Fatalf("got %+v, want a watch blocking on %s", watches, tt.want)
+			}
+		})
+	}
+
+	t.Run("not covering", func(t *testing.T) {
+		w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+		_, err := w.WatchTxn(context.Background(), ops, watcher.WithCoveringPrefix("app/cache/"))
+		if !errors.Is(err, watcher.ErrInvalidOptions) {
+			t.Fatalf("got %v, want ErrInvalidOptions", err)
+		}
+	})
+}
+
+// txnOptionsKV records the QueryOptions of every transaction
+type txnOptionsKV struct {
+	*watchertest.KV
+	opts chan consul.QueryOptions
+}
+
+func (kv txnOptionsKV) Txn(
+	txn consul.KVTxnOps, q *consul.QueryOptions,
+) (bool, *consul.KVTxnResponse, *consul.QueryMeta, error) {
+	select {
+	case kv.opts <- *q:
+	default:
+	}
+	return kv.KV.Txn(txn, q)
+}
+
+func TestWatchTxnQueryOptions(t *testing.T) {
+	checkGoroutines(t)
+	kv := txnOptionsKV{KV: watchertest.NewKV(), opts: make(chan consul.QueryOptions, 10)}
+	kv.Put("app/a", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := w.WatchTxn(ctx, consul.KVTxnOps{{Verb: consul.KVGet, Key: "app/a"}},
+		watcher.WithMaxAge(time.Minute), watcher.WithQueryMutator(func(q *consul.QueryOptions) {
+			q.Namespace = "team"
+			q.Token = "secret"
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-results
+	<-kv.opts
+	kv.Put("app/a", []byte("2"))
+	<-results
+
+	// the transaction after the blocking query returned reads with its options but doesn't block itself
+	opts := <-kv.opts
+	if opts.Namespace != "team" || opts.Token != "secret" || opts.MaxAge != time.Minute || !opts.AllowStale {
+		t.Fatalf("got %+v, want the options of the blocking query", opts)
+	}
+	if opts.WaitIndex != 0 || opts.WaitHash != "" || opts.WaitTime != 0 {
+		t.Fatalf("got wait index %d hash %q time %s, want no blocking", opts.WaitIndex, opts.WaitHash, opts.WaitTime)
+	}
+	if opts.Context() == context.Background() {
+		t.Fatal("got the background context, want the context of the watch")
+	}
+}
@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"fmt"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// WithValidator checks every new value of a watch with validator before it is emitted. A value for which
+// validator returns an error is withheld and the error is passed to the error handler, the consumer keeps
+// the last valid value and the watch continues with the next change. Tree watches validate every pair and
+// withhold the whole tree if any pair is invalid. Missing keys are not validated.
+func WithValidator(validator func(*consul.KVPair) error) WatchOption {
+	return func(o *watchOptions) {
+		o.validator = validator
+	}
+}
+
+// validatePair returns the error of the validator for pair, nil for a missing key or without validator
+func (o *watchOptions) validatePair(pair *consul.KVPair) error {
+	if o.validator == nil || pair == nil {
+		return nil
+	}
+	if err := o.validator(pair); err != nil {
+		return fmt.Errorf("validate %s: %w", pair.Key, err)
+	}
+
+	return nil
+}
+
+// validatePairs returns the error of the validator for the first invalid pair of pairs
+func (o *watchOptions) validatePairs(pairs consul.KVPairs) error {
+	for _, pair := range pairs {
+		if err := o.validatePair(pair); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package watcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestValidator(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("config", []byte(`{"port":80}`))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	errInvalid := errors.New("invalid JSON")
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "config",
+		watcher.WithValidator(func(pair *consul.KVPair) error {
+			if !json.Valid(pair.Value) {
+				return errInvalid
+			}
+			return nil
+		}),
+		watcher.WithErrorHandler(func(err error) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; string(pair.Value) != `{"port":80}` {
+		t.Fatalf("got %s, want the valid value", pair.Value)
+	}
+
+	// the invalid value is withheld and reported
+	kv.Put("config", []byte(`{"port":`))
+	if err := <-errs; !errors.Is(err, errInvalid) {
+		t.Fatalf("got %v, want the validation error", err)
+	}
+	select {
+	case pair := <-pairs:
+		t.Fatalf("got the invalid value %s", pair.Value)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("config", []byte(`{"port":8080}`))
+	if pair := <-pairs; string(pair.Value) != `{"port":8080}` {
+		t.Fatalf("got %s, want the next valid value", pair.Value)
+	}
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"context"
+)
+
+// WatchKeyBytes watches for changes to the value of a key and emits the value only.
+// An existing key with an empty value emits an empty non-nil slice, a missing or deleted key emits nil.
+// Changes are detected on the value, so rewriting the same value doesn't emit again.
+func (w *Watcher) WatchKeyBytes(ctx context.Context, key string, opts ...WatchOption) (<-chan []byte, error) {
+	opts = append([]WatchOption{WithIdentityFunc(valueIdentity)}, opts...)
+	pairs, err := w.WatchKey(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+
+		for pair := range pairs {
+			var value []byte
+			if pair != nil {
+				value = append([]byte{}, pair.Value...)
+			}
+
+			select {
+			case out <- value:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// WatchKeyString watches for changes to the value of a key and emits the value as string.
+// A missing or deleted key emits an empty string, just like an existing key with an empty value,
+// the change between both states is not emitted.
+func (w *Watcher) WatchKeyString(ctx context.Context, key string, opts ...WatchOption) (<-chan string, error) {
+	values, err := w.WatchKeyBytes(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan string)
+	go func() {
+		defer close(out)
+
+		first := true
+		var last string
+		for value := range values {
+			if !first && string(value) == last {
+				continue
+			}
+			first = false
+			last = string(value)
+
+			select {
+			case out <- last:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package watcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestWatchKeyBytes(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("a"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, err := w.WatchKeyBytes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := <-values; string(value) != "a" {
+		t.Fatalf("got %q, want a", value)
+	}
+
+	// rewriting the same value doesn't emit
+	kv.Put("key", []byte("a"))
+	kv.Put("key", []byte("b"))
+	if value := <-values; string(value) != "b" {
+		t.Fatalf("got %q, want b", value)
+	}
+
+	kv.Delete("key")
+	if value := <-values; value != nil {
+		t.Fatalf("got %q, want nil for the deleted key", value)
+	}
+
+	kv.Put("key", nil)
+	if value := <-values; value == nil || len(value) != 0 {
+		t.Fatalf("got %q, want an empty non-nil value", value)
+	}
+}
+
+func TestWatchKeyString(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("a"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, err := w.WatchKeyString(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := <-values; value != "a" {
+		t.Fatalf("got %q, want a", value)
+	}
+
+	kv.Put("key", []byte("b"))
+	if value := <-values; value != "b" {
+		t.Fatalf("got %q, want b", value)
+	}
+
+	kv.Delete("key")
+	if value := <-values; value != "" {
+		t.Fatalf("got %q, want empty for the deleted key", value)
+	}
+
+	// an empty value is the same state as the missing key
+	kv.Put("key", nil)
+	select {
+	case value := <-values:
+		t.Fatalf("got %q for the empty value after the deletion", value)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
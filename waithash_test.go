@@ -0,0 +1,65 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// hashKV returns a content hash with every response and records the WaitHash of every query
+type hashKV struct {
+	*watchertest.KV
+	hashes chan string
+}
+
+func (kv *hashKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	kv.hashes <- q.WaitHash
+	pair, meta, err := kv.KV.Get(key, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	withHash := *meta
+	withHash.LastContentHash = fmt.Sprintf("hash-%d", meta.LastIndex)
+	return pair, &withHash, nil
+}
+
+func TestWaitHash(t *testing.T) {
+	checkGoroutines(t)
+	tests := []struct {
+		name string
+		opts []watcher.WatchOption
+		want string
+	}{
+		{name: "enabled", opts: []watcher.WatchOption{watcher.WithWaitHash()}, want: "hash-2"},
+		{name: "disabled", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := &hashKV{KV: watchertest.NewKV(), hashes: make(chan string, 10)}
+			kv.Put("key", []byte("value"))
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "key", tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-pairs
+
+			if hash := <-kv.hashes; hash != "" {
+				t.Fatalf("got WaitHash %q for the first query, want none", hash)
+			}
+			if hash := <-kv.hashes; hash != tt.want {
+				t.Fatalf("got WaitHash %q, want %q", hash, tt.want)
+			}
+		})
+	}
+}
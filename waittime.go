@@ -0,0 +1,39 @@
+package watcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// defaultWaitTime is the wait time of watches started without WithWaitTime, see SetDefaultWaitTime
+var defaultWaitTime = int64(DefaultWaitTime)
+
+// SetDefaultWaitTime changes the wait time of the blocking queries of all watches that are started afterwards
+// without WithWaitTime, running watches keep their wait time. It returns ErrInvalidOptions if d is not positive
+// or exceeds DefaultWaitTime, the maximum Consul allows. It is safe to call concurrently.
+func SetDefaultWaitTime(d time.Duration) error {
+	if err := checkWaitTime(d); err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&defaultWaitTime, int64(d))
+	return nil
+}
+
+// WithWaitTime sets the wait time of the blocking queries of a watch. Shorter wait times make idle watches
+// return more often. Starting the watch fails with ErrInvalidOptions if d is not positive or exceeds DefaultWaitTime.
+func WithWaitTime(d time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.waitTime = d
+	}
+}
+
+// checkWaitTime returns ErrInvalidOptions for a wait time Consul doesn't accept
+func checkWaitTime(d time.Duration) error {
+	if d <= 0 || d > DefaultWaitTime {
+		return fmt.Errorf("%w: wait time %s must be positive and at most %s", ErrInvalidOptions, d, DefaultWaitTime)
+	}
+
+	return nil
+}
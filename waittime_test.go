@@ -0,0 +1,81 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// waitTimeKV records the wait time of the blocking queries of every key in waitTimes
+type waitTimeKV struct {
+	*watchertest.KV
+	waitTimes map[string]chan time.Duration
+}
+
+func (kv *waitTimeKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	if waitTimes, ok := kv.waitTimes[key]; ok && q.WaitIndex > 0 {
+		select {
+		case waitTimes <- q.WaitTime:
+		default:
+		}
+	}
+	return kv.KV.Get(key, q)
+}
+
+func TestSetDefaultWaitTime(t *testing.T) {
+	checkGoroutines(t)
+	defer func() {
+		if err := watcher.SetDefaultWaitTime(watcher.DefaultWaitTime); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	kv := &waitTimeKV{KV: watchertest.NewKV(), waitTimes: map[string]chan time.Duration{
+		"running": make(chan time.Duration, 10),
+		"started": make(chan time.Duration, 10),
+	}}
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running, err := w.WatchKey(ctx, "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-running
+	if got := <-kv.waitTimes["running"]; got != watcher.DefaultWaitTime {
+		t.Fatalf("got %s, want the default wait time", got)
+	}
+
+	if err := watcher.SetDefaultWaitTime(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	started, err := w.WatchKey(ctx, "started")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if got := <-kv.waitTimes["started"]; got != time.Minute {
+		t.Fatalf("got %s, want the new wait time for the new watch", got)
+	}
+
+	// the running watch keeps its wait time for its next query
+	kv.Put("running", []byte("value"))
+	<-running
+	if got := <-kv.waitTimes["running"]; got != watcher.DefaultWaitTime {
+		t.Fatalf("got %s, want the default wait time for the running watch", got)
+	}
+}
+
+func TestSetDefaultWaitTimeInvalid(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second, watcher.DefaultWaitTime + time.Second} {
+		if err := watcher.SetDefaultWaitTime(d); !errors.Is(err, watcher.ErrInvalidOptions) {
+			t.Fatalf("got %v for %s, want ErrInvalidOptions", err, d)
+		}
+	}
+}
@@ -2,167 +2,356 @@ package watcher
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	consul "github.com/hashicorp/consul/api"
 )
 
-// DefaultWaitTime is the maximum wait time allowed by Consul
+// DefaultWaitTime is the maximum wait time allowed by Consul and the default wait time of watches,
+// see SetDefaultWaitTime and WithWaitTime
 const DefaultWaitTime = 10 * time.Minute
 
-// Watcher is a wrapper around the Consul client that watches for changes to a keys and directories
+// Watcher is a wrapper around the Consul client that watches for changes to a keys and directories.
+// A Watcher is safe for concurrent use, any number of watches can be started from the same Watcher,
+// also on overlapping keys and paths. Every watch keeps its own query state, debounce timer and backoff,
+// only the Watcher options like the circuit breaker are shared.
 type Watcher struct {
-	consul       *consul.Client
-	backoff      *backoff.ExponentialBackOff
+	ctx          context.Context
+	kv           KVClient
+	catalog      CatalogClient
+	status       StatusClient
+	connect      ConnectClient
+	retryTime    time.Duration
 	debounceTime time.Duration
+	breaker      *circuitBreaker
+	requests     semaphore
+	// deliveryWorkers limits the callbacks running at the same time, nil if they run on the watch goroutine
+	deliveryWorkers semaphore
+	keyPrefix       string
+	// trimPrefix strips keyPrefix from the keys of emitted key value pairs
+	trimPrefix bool
+
+	watchesMu sync.Mutex
+	watches   map[*watchState]struct{}
+	// failed are the watches that died in the order they ended, at most maxFailedWatches are kept
+	failed []*watchState
+	totals counters
 }
 
-// New returns a new Watcher
-func New(consulClient *consul.Client, retryTime time.Duration, debounceTime time.Duration) *Watcher {
-	bf := backoff.NewExponentialBackOff()
-	bf.InitialInterval = retryTime
-	return &Watcher{
-		consul:       consulClient,
-		backoff:      bf,
+// Option configures a Watcher
+type Option func(w *Watcher)
+
+// New returns a new Watcher. The Consul client may be nil if all clients the used watches depend on are replaced
+// with WithKVClient, WithCatalogClient, WithStatusClient and WithConnectClient.
+func New(consulClient *consul.Client, retryTime time.Duration, debounceTime time.Duration, opts ...Option) *Watcher {
+	return NewWithContext(context.Background(), consulClient, retryTime, debounceTime, opts...)
+}
+
+// NewWithContext returns a new Watcher bound to ctx. Cancelling ctx is a global shutdown:
+// all active watches and background machinery of the Watcher stop and watches started afterwards end immediately.
+func NewWithContext(
+	ctx context.Context, consulClient *consul.Client, retryTime time.Duration, debounceTime time.Duration, opts ...Option,
+) *Watcher {
+	w := &Watcher{
+		ctx:          ctx,
+		retryTime:    retryTime,
 		debounceTime: debounceTime,
+		watches:      make(map[*watchState]struct{}),
+	}
+	if consulClient != nil {
+		w.kv = consulClient.KV()
+		w.catalog = consulClient.Catalog()
+		w.status = consulClient.Status()
+		w.connect = consulClient.Connect()
 	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
 }
 
-// WatchTree watches for changes to a directory and emit key value pairs
-func (w *Watcher) WatchTree(ctx context.Context, path string) (<-chan consul.KVPairs, error) {
-	out := make(chan consul.KVPairs)
-	kv := w.consul.KV()
-	var debounceTimer *time.Timer
-	var debounceStart time.Time
+// Stats contains information about the state of a Watcher
+type Stats struct {
+	// Breaker is the state of the shared circuit breaker, BreakerDisabled if not configured
+	Breaker BreakerState
+	// Emissions is the number of values emitted by all watches
+	Emissions uint64
+	// Retries is the number of queries of all watches retried after an error
+	Retries uint64
+}
 
-	opts := &consul.QueryOptions{
-		AllowStale:        true,
-		RequireConsistent: false,
-		UseCache:          true,
-		WaitTime:          DefaultWaitTime,
+// Stats returns the current Stats of the Watcher
+func (w *Watcher) Stats() Stats {
+	return Stats{
+		Breaker:   w.breaker.State(),
+		Emissions: atomic.LoadUint64(&w.totals.emissions),
+		Retries:   atomic.LoadUint64(&w.totals.retries),
 	}
+}
 
-	go func() {
-		defer close(out)
+// Ping checks whether Consul can currently be reached with the client of the Watcher, e.g. for a readiness probe.
+// It asks the agent for the current leader independent of any running watch and returns an error if the request
+// fails or the cluster has no leader.
+func (w *Watcher) Ping(ctx context.Context) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+	if w.status == nil {
+		return missingClient("Status")
+	}
 
-			kvPairs, meta, err := kv.List(path, opts.WithContext(ctx))
-			if err != nil {
-				if consul.IsRetryableError(err) {
-					opts.WaitIndex = 0
-					select {
-					case <-ctx.Done():
-						return
-					case <-time.After(w.backoff.NextBackOff()):
-						continue
-					}
-				}
+	opts := &consul.QueryOptions{}
+	leader, err := w.status.LeaderWithQueryOptions(opts.WithContext(ctx))
+	if err != nil {
+		return classifyError(err)
+	}
+	if leader == "" {
+		return ErrNoLeader
+	}
 
-				return
-			}
+	return nil
+}
 
-			w.backoff.Reset()
-			if opts.WaitIndex != meta.LastIndex {
-				if debounceTimer != nil {
-					debounceTimer.Stop()
-				}
-				if opts.WaitIndex <= 0 ||
-					(!debounceStart.IsZero() && time.Since(debounceStart) > 2*w.debounceTime) {
-					out <- kvPairs
-					debounceStart = time.Time{}
-				} else {
-					if debounceStart.IsZero() {
-						debounceStart = time.Now()
-					}
-					debounceTimer = time.AfterFunc(w.debounceTime, func() {
-						select {
-						case out <- kvPairs:
-						case <-ctx.Done():
-							return
-						}
-						debounceStart = time.Time{}
-					})
-				}
-				opts.WaitIndex = meta.LastIndex
-			}
+// newBackOff returns the backoff for retrying a single watch, it retries forever
+func (w *Watcher) newBackOff() *backoff.ExponentialBackOff {
+	bf := backoff.NewExponentialBackOff()
+	bf.InitialInterval = w.retryTime
+	bf.MaxElapsedTime = 0
+	bf.Reset()
+	return bf
+}
+
+// watchContext returns a context that is done when either ctx or the Watcher context is done.
+// The returned cancel func must be called to release resources once the watch ends.
+func (w *Watcher) watchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-w.ctx.Done():
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
 
-	return out, nil
+	return ctx, cancel
+}
+
+// WatchTree watches for changes to a directory and emit key value pairs. A directory without keys,
+// also one that was never created, emits an empty and never a nil KVPairs.
+func (w *Watcher) WatchTree(ctx context.Context, path string, opts ...WatchOption) (<-chan consul.KVPairs, error) {
+	o := w.newWatchOptions(opts)
+	return startWatch(ctx, w, o, w.treeSource(path, o), valueOnly[consul.KVPairs])
 }
 
-// WatchKey watches for changes to a key and emits a key value pair
-func (w *Watcher) WatchKey(ctx context.Context, key string) (<-chan *consul.KVPair, error) {
-	out := make(chan *consul.KVPair)
-	kv := w.consul.KV()
-	var debounceStart time.Time
-	var debounceTimer *time.Timer
+// WatchKey watches for changes to a key and emits a key value pair. A missing or deleted key emits nil,
+// an existing key with an empty value emits a pair with an empty Value. The change detection always
+// treats both as different states, also with a custom WithIdentityFunc.
+func (w *Watcher) WatchKey(ctx context.Context, key string, opts ...WatchOption) (<-chan *consul.KVPair, error) {
+	o := w.newWatchOptions(opts)
+	return startWatch(ctx, w, o, w.keySource(key, o), valueOnly[*consul.KVPair])
+}
 
-	opts := &consul.QueryOptions{
-		AllowStale:        true,
-		RequireConsistent: false,
-		UseCache:          true,
-		WaitTime:          DefaultWaitTime,
+// WithKeyPrefix prepends prefix to every key and path watched by the Watcher, so all watches
+// can be namespaced under a common root. A slash is added between prefix and key if neither has one,
+// a trailing slash of a tree path is kept. Emitted key value pairs contain the full key including prefix
+// unless WithTrimPrefix is set.
+func WithKeyPrefix(prefix string) Option {
+	return func(w *Watcher) {
+		w.keyPrefix = prefix
 	}
+}
 
-	go func() {
-		defer close(out)
+// WithTrimPrefix strips the prefix of WithKeyPrefix and its separating slash from the keys of emitted key
+// value pairs, so a watch of "app/" emits "app/a" instead of "team/app/a". The pairs are copies, the ones
+// returned by the client are not modified. Without WithKeyPrefix it has no effect.
+func WithTrimPrefix() Option {
+	return func(w *Watcher) {
+		w.trimPrefix = true
+	}
+}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+// dirPath returns path as a directory with a single trailing slash, it is empty for the root
+func dirPath(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return ""
+	}
 
-			kvPair, meta, err := kv.Get(key, opts.WithContext(ctx))
-			if err != nil {
-				if consul.IsRetryableError(err) {
-					opts.WaitIndex = 0
-					select {
-					case <-ctx.Done():
-						return
-					case <-time.After(w.backoff.NextBackOff()):
-						continue
-					}
-				}
+	return path + "/"
+}
+
+// dirKey returns path as a directory prefix with a trailing slash as it appears in the keys of emitted pairs,
+// it is empty for the root
+func (w *Watcher) dirKey(path string) string {
+	key := strings.TrimSuffix(w.emittedKey(path), "/")
+	if key == "" {
+		return ""
+	}
 
-				return
+	return key + "/"
+}
+
+// emittedKey returns key as it appears in emitted pairs, with the key prefix unless WithTrimPrefix is set
+func (w *Watcher) emittedKey(key string) string {
+	return w.trimKey(w.fullKey(key))
+}
+
+// trimKey strips the key prefix from a key read from Consul if WithTrimPrefix is set
+func (w *Watcher) trimKey(key string) string {
+	if !w.trimPrefix || w.keyPrefix == "" {
+		return key
+	}
+
+	return strings.TrimPrefix(key, strings.TrimSuffix(w.keyPrefix, "/")+"/")
+}
+
+// trimPair returns a copy of pair with the key prefix stripped from its key if WithTrimPrefix is set
+func (w *Watcher) trimPair(pair *consul.KVPair) *consul.KVPair {
+	if pair == nil || !w.trimPrefix || w.keyPrefix == "" {
+		return pair
+	}
+
+	trimmed := *pair
+	trimmed.Key = w.trimKey(pair.Key)
+	return &trimmed
+}
+
+// trimPairs returns pairs with the key prefix stripped from their keys if WithTrimPrefix is set
+func (w *Watcher) trimPairs(pairs consul.KVPairs) consul.KVPairs {
+	if !w.trimPrefix || w.keyPrefix == "" {
+		return pairs
+	}
+
+	trimmed := make(consul.KVPairs, len(pairs))
+	for i, pair := range pairs {
+		trimmed[i] = w.trimPair(pair)
+	}
+	return trimmed
+}
+
+// missingClient returns the error for a watch or call that depends on a client the Watcher doesn't have
+func missingClient(name string) error {
+	return fmt.Errorf("%w: no %s client, pass a Consul client or use With%sClient", ErrInvalidOptions, name, name)
+}
+
+// fullKey returns key with the key prefix of the Watcher applied
+func (w *Watcher) fullKey(key string) string {
+	if w.keyPrefix == "" {
+		return key
+	}
+
+	return strings.TrimSuffix(w.keyPrefix, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+// treeSource returns the source for watching all keys below path
+func (w *Watcher) treeSource(path string, o *watchOptions) source[consul.KVPairs] {
+	kv := w.kv
+	path = w.fullKey(path)
+
+	var include map[string]struct{}
+	if o.includeKeys != nil {
+		include = make(map[string]struct{}, len(o.includeKeys))
+		for _, key := range o.includeKeys {
+			include[w.fullKey(key)] = struct{}{}
+		}
+	}
+
+	list := func(opts *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+		return kv.List(path, opts)
+	}
+	if o.autoSplit {
+		list = (&splitTree{kv: kv, path: path, o: o, retry: splitRetryInterval}).list
+	}
+
+	src := source[consul.KVPairs]{
+		kind:   KindTree,
+		target: path,
+		fetch: func(opts *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+			pairs, meta, err := list(opts)
+			if err != nil {
+				return nil, meta, err
+			}
+			// List returns nil for a prefix that never existed, consumers get the same empty tree as after deletes
+			if pairs == nil {
+				pairs = consul.KVPairs{}
+			}
+			if include == nil && o.flagsFilter == nil {
+				return w.trimPairs(pairs), meta, nil
 			}
 
-			// reset backoff after successful load
-			w.backoff.Reset()
-			if opts.WaitIndex != meta.LastIndex {
-				if debounceTimer != nil {
-					debounceTimer.Stop()
+			filtered := make(consul.KVPairs, 0, len(pairs))
+			for _, pair := range pairs {
+				if _, ok := include[pair.Key]; include != nil && !ok {
+					continue
 				}
-
-				// don't debounce and wait if we start fresh without wait index
-				if opts.WaitIndex <= 0 ||
-					(!debounceStart.IsZero() && time.Since(debounceStart) > 2*w.debounceTime) {
-					out <- kvPair
-					debounceStart = time.Time{}
-				} else {
-					if debounceStart.IsZero() {
-						debounceStart = time.Now()
-					}
-
-					debounceTimer = time.AfterFunc(w.debounceTime, func() {
-						out <- kvPair
-						debounceStart = time.Time{}
-					})
+				if o.flagsFilter != nil && !o.flagsFilter(pair.Flags) {
+					continue
 				}
-				opts.WaitIndex = meta.LastIndex
+				filtered = append(filtered, pair)
 			}
-		}
-	}()
+			return w.trimPairs(filtered), meta, nil
+		},
+		identity: treeHash,
+	}
+	if o.treeIdentity == TreeIdentityIndex {
+		src.identity = treeIndexHash
+	}
+	if o.copyValues {
+		src.copy = copyPairs
+	}
+	if o.validator != nil {
+		src.validate = o.validatePairs
+	}
+	if kv == nil {
+		src.err = missingClient("KV")
+	}
+
+	return src
+}
+
+// keySource returns the source for watching a single key
+func (w *Watcher) keySource(key string, o *watchOptions) source[*consul.KVPair] {
+	kv := w.kv
+	key = w.fullKey(key)
+	src := source[*consul.KVPair]{
+		kind:   KindKey,
+		target: key,
+		fetch: func(opts *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+			pair, meta, err := kv.Get(key, opts)
+			if err == nil && pair == nil && o.defaultValue != nil {
+				pair = o.defaultPair(key)
+			}
+			return w.trimPair(pair), meta, err
+		},
+		identity: o.keyIdentity,
+		hash:     pairHash,
+		exists: func(pair *consul.KVPair) bool {
+			return pair != nil && !IsDefault(pair)
+		},
+	}
+	if o.defaultValue != nil {
+		src.isDefault = IsDefault
+	}
+	if o.validator != nil {
+		src.validate = o.validatePair
+	}
+	if o.copyValues {
+		src.copy = copyPair
+	}
+	src.fallback = func(data []byte) *consul.KVPair {
+		return &consul.KVPair{Key: w.trimKey(key), Value: data}
+	}
+	if kv == nil {
+		src.err = missingClient("KV")
+	}
 
-	return out, nil
+	return src
 }
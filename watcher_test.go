@@ -0,0 +1,489 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+func TestMissingClient(t *testing.T) {
+	w := watcher.New(nil, 10*time.Millisecond, 0)
+	ctx := context.Background()
+
+	calls := map[string]func() error{
+		"WatchKey": func() error {
+			_, err := w.WatchKey(ctx, "key")
+			return err
+		},
+		"WatchTree": func() error {
+			_, err := w.WatchTree(ctx, "tree")
+			return err
+		},
+		"WatchKeyAllDatacenters": func() error {
+			_, err := w.WatchKeyAllDatacenters(ctx, "key")
+			return err
+		},
+		"WatchIntentions": func() error {
+			_, err := w.WatchIntentions(ctx)
+			return err
+		},
+		"Ping": func() error {
+			return w.Ping(ctx)
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, watcher.ErrInvalidOptions) {
+				t.Fatalf("got %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+}
+
+func TestMissingClientReplaced(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("key", []byte("value"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs, err := w.WatchKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair := <-pairs; pair == nil || string(pair.Value) != "value" {
+		t.Fatalf("got %v, want value", pair)
+	}
+}
+
+func TestWatchTreeIndexBumpWithoutChange(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	polls := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTree(ctx, "app/", watcher.WithOnPollComplete(func(changed bool) {
+		polls <- changed
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-trees
+	<-polls
+
+	// a write outside the tree advances the index of the tree query without changing its content
+	kv.Put("other", []byte("1"))
+	if changed := <-polls; changed {
+		t.Fatal("got a change for an index bump without new content")
+	}
+	select {
+	case tree := <-trees:
+		t.Fatalf("got emission %v for an index bump without new content", treeKeys(tree))
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestNewWithContextShutdown(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := watcher.NewWithContext(ctx, nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	pairs, err := w.WatchKey(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trees, err := w.WatchTree(context.Background(), "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pairs
+	<-trees
+
+	// cancelling the context of the Watcher ends all watches
+	cancel()
+	for range pairs {
+	}
+	for range trees {
+	}
+	waitFor(t, func() bool {
+		return len(w.List()) == 0
+	})
+
+	// watches started afterwards end immediately
+	later, err := w.WatchKey(context.Background(), "key")
+	if err == nil {
+		for range later {
+		}
+	}
+}
+
+func TestConcurrentWatches(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("0"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// watches on overlapping paths are started concurrently and each sees the same update
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	started := make(chan struct{}, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pairs, err := w.WatchKey(ctx, "app/a")
+			if err != nil {
+				errs <- err
+				started <- struct{}{}
+				return
+			}
+			<-pairs
+			started <- struct{}{}
+			if pair := <-pairs; pair == nil || string(pair.Value) != "1" {
+				errs <- fmt.Errorf("WatchKey got %v, want 1", pair)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			trees, err := w.WatchTree(ctx, "app/")
+			if err != nil {
+				errs <- err
+				started <- struct{}{}
+				return
+			}
+			<-trees
+			started <- struct{}{}
+			if tree := <-trees; len(tree) != 1 || string(tree[0].Value) != "1" {
+				errs <- fmt.Errorf("WatchTree got %v, want app/a=1", treeKeys(tree))
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		<-started
+	}
+
+	kv.Put("app/a", []byte("1"))
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestIncludeKeys(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("1"))
+	kv.Put("app/c", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTree(ctx, "app/", watcher.WithIncludeKeys("app/a", "app/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := treeKeys(<-trees); !equalStrings(keys, []string{"app/a", "app/c"}) {
+		t.Fatalf("got %v, want only the included keys", keys)
+	}
+
+	// a change outside the list doesn't emit
+	kv.Put("app/b", []byte("2"))
+	select {
+	case tree := <-trees:
+		t.Fatalf("got emission %v for a change outside the list", treeKeys(tree))
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("app/c", []byte("2"))
+	tree := <-trees
+	if keys := treeKeys(tree); !equalStrings(keys, []string{"app/a", "app/c"}) || string(tree[1].Value) != "2" {
+		t.Fatalf("got %v, want app/c changed to 2", keys)
+	}
+}
+
+// statusClient answers Ping with leader or err
+type statusClient struct {
+	leader string
+	err    error
+}
+
+func (s statusClient) LeaderWithQueryOptions(*consul.QueryOptions) (string, error) {
+	return s.leader, s.err
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		status statusClient
+		want   error
+	}{
+		{name: "leader", status: statusClient{leader: "10.0.0.1:8300"}},
+		{name: "no leader", status: statusClient{}, want: watcher.ErrNoLeader},
+		{
+			name:   "permission denied",
+			status: statusClient{err: consul.StatusError{Code: http.StatusForbidden, Body: "ACL not found"}},
+			want:   watcher.ErrPermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithStatusClient(tt.status))
+			if err := w.Ping(context.Background()); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilContext(t *testing.T) {
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+	var ctx context.Context
+
+	calls := map[string]func() error{
+		"WatchKey": func() error {
+			_, err := w.WatchKey(ctx, "key")
+			return err
+		},
+		"WatchTree": func() error {
+			_, err := w.WatchTree(ctx, "tree")
+			return err
+		},
+		"SubscribeKey": func() error {
+			_, err := w.SubscribeKey(ctx, "key")
+			return err
+		},
+		"Ping": func() error {
+			return w.Ping(ctx)
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, watcher.ErrInvalidOptions) {
+				t.Fatalf("got %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	checkGoroutines(t)
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(watchertest.NewKV()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := w.WatchKey(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, err := w.WatchTree(ctx, "tree"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(w.List()) != 0 {
+		t.Fatal("got a watch listed for a cancelled context")
+	}
+}
+
+// flagsKV sets the Flags of the listed keys from flags
+type flagsKV struct {
+	*watchertest.KV
+	flags map[string]uint64
+}
+
+func (kv flagsKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	pairs, meta, err := kv.KV.List(prefix, q)
+	for _, pair := range pairs {
+		pair.Flags = kv.flags[pair.Key]
+	}
+	return pairs, meta, err
+}
+
+func TestFlagsFilter(t *testing.T) {
+	checkGoroutines(t)
+	kv := flagsKV{KV: watchertest.NewKV(), flags: map[string]uint64{"app/a": 1, "app/b": 2, "app/c": 1}}
+	kv.Put("app/a", []byte("1"))
+	kv.Put("app/b", []byte("1"))
+	kv.Put("app/c", []byte("1"))
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTree(ctx, "app/", watcher.WithFlagsFilter(func(flags uint64) bool {
+		return flags == 1
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := treeKeys(<-trees); !equalStrings(keys, []string{"app/a", "app/c"}) {
+		t.Fatalf("got %v, want the keys with flag 1", keys)
+	}
+
+	// a change of a key with another flag doesn't emit
+	kv.Put("app/b", []byte("2"))
+	select {
+	case tree := <-trees:
+		t.Fatalf("got emission %v for a key with another flag", treeKeys(tree))
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	kv.Put("app/a", []byte("2"))
+	if tree := <-trees; len(tree) != 2 || string(tree[0].Value) != "2" {
+		t.Fatalf("got %v, want app/a changed to 2", treeKeys(tree))
+	}
+}
+
+func TestWatchTreeNeverCreated(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	w := watcher.New(nil, 10*time.Millisecond, 0, watcher.WithKVClient(kv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees, err := w.WatchTree(ctx, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the prefix was never created, the first emission is empty but not nil
+	if tree := <-trees; tree == nil || len(tree) != 0 {
+		t.Fatalf("got %v, want an empty non-nil tree", tree)
+	}
+
+	kv.Put("app/a", []byte("1"))
+	if keys := treeKeys(<-trees); !equalStrings(keys, []string{"app/a"}) {
+		t.Fatalf("got %v, want the new key", keys)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		trim   bool
+		path   string
+		want   []string
+	}{
+		{name: "tree", prefix: "team", path: "app/", want: []string{"team/app/a", "team/app/b"}},
+		{name: "prefix with trailing slash", prefix: "team/", path: "app/", want: []string{"team/app/a", "team/app/b"}},
+		{name: "path with leading slash", prefix: "team/", path: "/app/", want: []string{"team/app/a", "team/app/b"}},
+		{
+			name:   "path without trailing slash",
+			prefix: "team",
+			path:   "app",
+			want:   []string{"team/app", "team/app/a", "team/app/b"},
+		},
+		{
+			name:   "root",
+			prefix: "team/",
+			path:   "",
+			want:   []string{"team/app", "team/app/a", "team/app/b", "team/other"},
+		},
+		{name: "trim", prefix: "team/", trim: true, path: "app/", want: []string{"app/a", "app/b"}},
+		{name: "trim root", prefix: "team", trim: true, path: "", want: []string{"app", "app/a", "app/b", "other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("team/app", []byte("1"))
+			kv.Put("team/app/a", []byte("1"))
+			kv.Put("team/app/b", []byte("1"))
+			kv.Put("team/other", []byte("1"))
+			kv.Put("app/a", []byte("1"))
+			opts := []watcher.Option{watcher.WithKVClient(kv), watcher.WithKeyPrefix(tt.prefix)}
+			if tt.trim {
+				opts = append(opts, watcher.WithTrimPrefix())
+			}
+			w := watcher.New(nil, 10*time.Millisecond, 0, opts...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			trees, err := w.WatchTree(ctx, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := treeKeys(<-trees); !equalStrings(keys, tt.want) {
+				t.Fatalf("got %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyPrefixKey(t *testing.T) {
+	tests := []struct {
+		name string
+		trim bool
+		want string
+	}{
+		{name: "full key", want: "team/app/a"},
+		{name: "trim", trim: true, want: "app/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			kv := watchertest.NewKV()
+			kv.Put("team/app/a", []byte("1"))
+			opts := []watcher.Option{watcher.WithKVClient(kv), watcher.WithKeyPrefix("team/")}
+			if tt.trim {
+				opts = append(opts, watcher.WithTrimPrefix())
+			}
+			w := watcher.New(nil, 10*time.Millisecond, 0, opts...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pairs, err := w.WatchKey(ctx, "app/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pair := <-pairs
+			if pair == nil || pair.Key != tt.want || string(pair.Value) != "1" {
+				t.Fatalf("got %v, want %s=1", pair, tt.want)
+			}
+
+			// the pairs of the client keep their full key
+			stored, _, err := kv.Get("team/app/a", nil)
+			if err != nil || stored.Key != "team/app/a" {
+				t.Fatalf("got %v %v, want the stored key unchanged", stored, err)
+			}
+		})
+	}
+}
+
+func TestTrimPrefixMergedTrees(t *testing.T) {
+	checkGoroutines(t)
+	kv := watchertest.NewKV()
+	kv.Put("team/defaults/a", []byte("1"))
+	kv.Put("team/overrides/a", []byte("2"))
+	w := watcher.New(nil, 10*time.Millisecond, 0,
+		watcher.WithKVClient(kv), watcher.WithKeyPrefix("team"), watcher.WithTrimPrefix())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// derived watches match the trimmed keys against their directories
+	views, err := w.WatchMergedTrees(ctx, []string{"defaults", "overrides"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view := <-views; len(view) != 1 || string(view["a"]) != "2" {
+		t.Fatalf("got %v, want a=2", view)
+	}
+}
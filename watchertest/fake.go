@@ -0,0 +1,183 @@
+// Package watchertest provides an in-memory watcher.KVWatcher to test consumers without Consul.
+//
+// A consumer under test gets the Fake instead of a *watcher.Watcher and the test pushes updates:
+//
+//	fake := watchertest.New()
+//	go consumer.Run(ctx, fake) // calls fake.WatchKey(ctx, "config/app")
+//	fake.PushKey("config/app", &consul.KVPair{Key: "config/app", Value: []byte("v2")})
+//
+// To test code that uses a real *watcher.Watcher, KV, Catalog and Status replace the Consul clients it depends on:
+//
+//	kv := watchertest.NewKV()
+//	w := watcher.New(nil, time.Second, 100*time.Millisecond, watcher.WithKVClient(kv))
+//	kv.Put("config/app", []byte("v2"))
+package watchertest
+
+import (
+	"context"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+)
+
+// Fake is an in-memory watcher.KVWatcher, values pushed for a key or path are delivered
+// to all watches started for it. It is safe for concurrent use.
+type Fake struct {
+	mu       sync.Mutex
+	err      error
+	keys     map[string][]*subscription[*consul.KVPair]
+	trees    map[string][]*subscription[consul.KVPairs]
+	watching chan struct{}
+}
+
+var _ watcher.KVWatcher = (*Fake)(nil)
+
+// subscription is a single watch started on the Fake
+type subscription[T any] struct {
+	ctx context.Context
+	out chan T
+
+	// mu is held while sending so the channel can't be closed during a send
+	mu     sync.Mutex
+	closed bool
+}
+
+// send delivers value unless the watch ended
+func (s *subscription[T]) send(value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.out <- value:
+	case <-s.ctx.Done():
+	}
+}
+
+// close closes the channel of the watch once
+func (s *subscription[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.out)
+	}
+}
+
+// New returns a new Fake
+func New() *Fake {
+	return &Fake{
+		keys:     make(map[string][]*subscription[*consul.KVPair]),
+		trees:    make(map[string][]*subscription[consul.KVPairs]),
+		watching: make(chan struct{}, 1),
+	}
+}
+
+// SetError makes all further watch calls return err, a nil err lets them succeed again
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+// Watching returns a channel that receives a value whenever a watch is started,
+// tests can use it to wait until the consumer is subscribed before pushing values
+func (f *Fake) Watching() <-chan struct{} {
+	return f.watching
+}
+
+// WatchKey starts a watch on key, its channel is closed when ctx is done or CloseKey is called
+func (f *Fake) WatchKey(ctx context.Context, key string, _ ...watcher.WatchOption) (<-chan *consul.KVPair, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	sub := &subscription[*consul.KVPair]{ctx: ctx, out: make(chan *consul.KVPair)}
+	f.keys[key] = append(f.keys[key], sub)
+	f.started(ctx, sub.close)
+
+	return sub.out, nil
+}
+
+// WatchTree starts a watch on path, its channel is closed when ctx is done or CloseTree is called
+func (f *Fake) WatchTree(ctx context.Context, path string, _ ...watcher.WatchOption) (<-chan consul.KVPairs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	sub := &subscription[consul.KVPairs]{ctx: ctx, out: make(chan consul.KVPairs)}
+	f.trees[path] = append(f.trees[path], sub)
+	f.started(ctx, sub.close)
+
+	return sub.out, nil
+}
+
+// PushKey delivers pair to all watches of key and blocks until each received it or ended
+func (f *Fake) PushKey(key string, pair *consul.KVPair) {
+	f.mu.Lock()
+	subs := append([]*subscription[*consul.KVPair](nil), f.keys[key]...)
+	f.mu.Unlock()
+
+	push(subs, pair)
+}
+
+// PushTree delivers pairs to all watches of path and blocks until each received them or ended
+func (f *Fake) PushTree(path string, pairs consul.KVPairs) {
+	f.mu.Lock()
+	subs := append([]*subscription[consul.KVPairs](nil), f.trees[path]...)
+	f.mu.Unlock()
+
+	push(subs, pairs)
+}
+
+// CloseKey ends all watches of key like a terminal error would.
+// It waits for pushes to these watches that are still in progress.
+func (f *Fake) CloseKey(key string) {
+	f.mu.Lock()
+	subs := f.keys[key]
+	delete(f.keys, key)
+	f.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// CloseTree ends all watches of path like a terminal error would.
+// It waits for pushes to these watches that are still in progress.
+func (f *Fake) CloseTree(path string) {
+	f.mu.Lock()
+	subs := f.trees[path]
+	delete(f.trees, path)
+	f.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// started signals a new watch and closes it once ctx is done
+func (f *Fake) started(ctx context.Context, close func()) {
+	select {
+	case f.watching <- struct{}{}:
+	default:
+	}
+
+	go func() {
+		<-ctx.Done()
+		close()
+	}()
+}
+
+func push[T any](subs []*subscription[T], value T) {
+	for _, sub := range subs {
+		sub.send(value)
+	}
+}
@@ -0,0 +1,90 @@
+package watchertest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+	"github.com/pteich/consul-kv-watcher/watchertest"
+)
+
+// logLevel is a consumer under test, it reports the level configured in Consul
+func logLevel(ctx context.Context, w watcher.KVWatcher, levels chan<- string) error {
+	pairs, err := w.WatchKey(ctx, "config/log-level")
+	if err != nil {
+		return err
+	}
+
+	for pair := range pairs {
+		level := "info"
+		if pair != nil {
+			level = string(pair.Value)
+		}
+		levels <- level
+	}
+	return nil
+}
+
+func ExampleFake() {
+	fake := watchertest.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	levels := make(chan string)
+	go func() {
+		_ = logLevel(ctx, fake, levels)
+		close(levels)
+	}()
+
+	// wait until the consumer watches before pushing
+	<-fake.Watching()
+	go fake.PushKey("config/log-level", &consul.KVPair{Key: "config/log-level", Value: []byte("debug")})
+	fmt.Println(<-levels)
+	go fake.PushKey("config/log-level", nil)
+	fmt.Println(<-levels)
+
+	fake.CloseKey("config/log-level")
+	for range levels {
+	}
+	// Output:
+	// debug
+	// info
+}
+
+func TestFakeTree(t *testing.T) {
+	fake := watchertest.New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	trees, err := fake.WatchTree(ctx, "config/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go fake.PushTree("config/", consul.KVPairs{{Key: "config/a"}})
+	if tree := <-trees; len(tree) != 1 || tree[0].Key != "config/a" {
+		t.Fatalf("got %v, want config/a", tree)
+	}
+
+	cancel()
+	if _, ok := <-trees; ok {
+		t.Fatal("channel not closed after ctx is done")
+	}
+}
+
+func TestFakeError(t *testing.T) {
+	fake := watchertest.New()
+	want := errors.New("failed")
+	fake.SetError(want)
+	if _, err := fake.WatchKey(context.Background(), "key"); !errors.Is(err, want) {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+
+	fake.SetError(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := fake.WatchKey(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+}
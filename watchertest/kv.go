@@ -0,0 +1,245 @@
+package watchertest
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	watcher "github.com/pteich/consul-kv-watcher"
+)
+
+// KV is an in-memory watcher.KVClient with blocking queries. It lets tests run a real *watcher.Watcher
+// without Consul by passing it with watcher.WithKVClient and changing keys with Put and Delete.
+// It is safe for concurrent use.
+type KV struct {
+	mu      sync.Mutex
+	err     error
+	index   uint64
+	pairs   map[string]*consul.KVPair
+	changed chan struct{}
+}
+
+var _ watcher.KVClient = (*KV)(nil)
+
+// NewKV returns an empty KV
+func NewKV() *KV {
+	return &KV{
+		index:   1,
+		pairs:   make(map[string]*consul.KVPair),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetError makes all further queries fail with err, a nil err lets them succeed again.
+// Queries that are blocking return err immediately.
+func (kv *KV) SetError(err error) {
+	kv.mu.Lock()
+	kv.err = err
+	kv.notify()
+	kv.mu.Unlock()
+}
+
+// Put creates or updates key and wakes up blocking queries
+func (kv *KV) Put(key string, value []byte) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.index++
+	pair, ok := kv.pairs[key]
+	if !ok {
+		pair = &consul.KVPair{Key: key, CreateIndex: kv.index}
+		kv.pairs[key] = pair
+	}
+	pair.Value = append([]byte(nil), value...)
+	pair.ModifyIndex = kv.index
+	kv.notify()
+}
+
+// Delete removes key and wakes up blocking queries
+func (kv *KV) Delete(key string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.index++
+	delete(kv.pairs, key)
+	kv.notify()
+}
+
+// notify wakes up all blocking queries, kv.mu must be held
+func (kv *KV) notify() {
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// Get returns a copy of key, nil if it doesn't exist
+func (kv *KV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	var pair *consul.KVPair
+	meta, err := kv.query(q, func() {
+		if p, ok := kv.pairs[key]; ok {
+			c := *p
+			pair = &c
+		}
+	})
+	return pair, meta, err
+}
+
+// List returns copies of all keys with prefix sorted by key
+func (kv *KV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
+	var pairs consul.KVPairs
+	meta, err := kv.query(q, func() {
+		pairs = kv.list(prefix)
+	})
+	return pairs, meta, err
+}
+
+// Keys returns the keys with prefix sorted, with a separator keys are truncated after the first separator
+// following the prefix like Consul does
+func (kv *KV) Keys(prefix, separator string, q *consul.QueryOptions) ([]string, *consul.QueryMeta, error) {
+	var keys []string
+	meta, err := kv.query(q, func() {
+		seen := make(map[string]struct{})
+		for _, pair := range kv.list(prefix) {
+			key := pair.Key
+			if separator != "" {
+				if i := strings.Index(key[len(prefix):], separator); i >= 0 {
+					key = key[:len(prefix)+i+len(separator)]
+				}
+			}
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	})
+	return keys, meta, err
+}
+
+// Txn executes the get and get-tree verbs of a read-only transaction, other verbs return an error
+func (kv *KV) Txn(txn consul.KVTxnOps, q *consul.QueryOptions) (bool, *consul.KVTxnResponse, *consul.QueryMeta, error) {
+	resp := &consul.KVTxnResponse{}
+	var txnErr error
+	meta, err := kv.query(&consul.QueryOptions{}, func() {
+		for _, op := range txn {
+			switch op.Verb {
+			case consul.KVGet:
+				if p, ok := kv.pairs[op.Key]; ok {
+					c := *p
+					resp.Results = append(resp.Results, &c)
+				}
+			case consul.KVGetTree:
+				resp.Results = append(resp.Results, kv.list(op.Key)...)
+			default:
+				txnErr = errors.New("watchertest: unsupported transaction verb " + string(op.Verb))
+				return
+			}
+		}
+	})
+	if err == nil {
+		err = txnErr
+	}
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	return true, resp, meta, nil
+}
+
+// list returns copies of all keys with prefix sorted by key, kv.mu must be held
+func (kv *KV) list(prefix string) consul.KVPairs {
+	var pairs consul.KVPairs
+	for key, p := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			c := *p
+			pairs = append(pairs, &c)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	return pairs
+}
+
+// query blocks while the index of kv is not above the WaitIndex of q, until the WaitTime elapsed
+// or the context of q is done, and calls read with kv.mu held
+func (kv *KV) query(q *consul.QueryOptions, read func()) (*consul.QueryMeta, error) {
+	if q == nil {
+		q = &consul.QueryOptions{}
+	}
+	ctx := q.Context()
+
+	var timeout <-chan time.Time
+	if q.WaitIndex > 0 && q.WaitTime > 0 {
+		timer := time.NewTimer(q.WaitTime)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for kv.err == nil && q.WaitIndex > 0 && kv.index <= q.WaitIndex {
+		changed := kv.changed
+		kv.mu.Unlock()
+		select {
+		case <-changed:
+			kv.mu.Lock()
+		case <-timeout:
+			kv.mu.Lock()
+			read()
+			return &consul.QueryMeta{LastIndex: kv.index, KnownLeader: true}, nil
+		case <-ctx.Done():
+			kv.mu.Lock()
+			return nil, ctx.Err()
+		}
+	}
+	if kv.err != nil {
+		return nil, kv.err
+	}
+
+	read()
+	return &consul.QueryMeta{LastIndex: kv.index, KnownLeader: true}, nil
+}
+
+// Catalog is a watcher.CatalogClient with a fixed list of datacenters
+type Catalog struct {
+	mu          sync.Mutex
+	datacenters []string
+}
+
+var _ watcher.CatalogClient = (*Catalog)(nil)
+
+// NewCatalog returns a Catalog with datacenters
+func NewCatalog(datacenters ...string) *Catalog {
+	return &Catalog{datacenters: datacenters}
+}
+
+// SetDatacenters replaces the datacenters, they are picked up on the next refresh of WatchKeyAllDatacenters
+func (c *Catalog) SetDatacenters(datacenters ...string) {
+	c.mu.Lock()
+	c.datacenters = datacenters
+	c.mu.Unlock()
+}
+
+// Datacenters returns a copy of the datacenters
+func (c *Catalog) Datacenters() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.datacenters...), nil
+}
+
+// Status is a watcher.StatusClient that reports a fixed leader or error
+type Status struct {
+	Leader string
+	Err    error
+}
+
+var _ watcher.StatusClient = (*Status)(nil)
+
+// LeaderWithQueryOptions returns the Leader or Err of s
+func (s *Status) LeaderWithQueryOptions(_ *consul.QueryOptions) (string, error) {
+	return s.Leader, s.Err
+}